@@ -0,0 +1,167 @@
+// Package audit appends a JSON-lines record of every credential issuance to a
+// local log, rotated by size, so it can be matched against CloudTrail.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cu-sts/filelock"
+)
+
+// Defaults for SetFile.
+const (
+	DefaultMaxSize = 10 << 20
+	DefaultBackups = 5
+
+	// lockTimeout is how long Log waits for another cu-sts to finish
+	// writing the log.
+	lockTimeout = 30 * time.Second
+)
+
+// A Record describes one set of credentials issued by STS. It never includes
+// the secret access key or session token.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Username    string    `json:"netid"`
+	Profile     string    `json:"profile"`
+	Account     string    `json:"account"`
+	Alias       string    `json:"alias,omitempty"`
+	Role        string    `json:"role"`
+	RoleARN     string    `json:"role_arn"`
+	AccessKeyID string    `json:"access_key_id"`
+	Expiration  time.Time `json:"expiration"`
+	Command     string    `json:"command"`
+	Protected   bool      `json:"protected,omitempty"`
+	// Confirmed is how a protected profile was confirmed, "prompt" or
+	// "flag" for --confirm-protected.
+	Confirmed string `json:"confirmed,omitempty"`
+}
+
+var (
+	mu      sync.Mutex
+	path    string
+	maxSize int64 = DefaultMaxSize
+	backups       = DefaultBackups
+	command string
+)
+
+// SetFile sets the log Log appends to, which rotates to path.1 (newest) to
+// path.<backups> when it would grow past maxSize bytes. Nothing is logged
+// until a file is set.
+func SetFile(p string, size int64, keep int) {
+	mu.Lock()
+	defer mu.Unlock()
+	path, maxSize, backups = p, size, keep
+}
+
+// SetCommand sets the command recorded with every record, e.g. "exec".
+func SetCommand(c string) {
+	mu.Lock()
+	defer mu.Unlock()
+	command = c
+}
+
+// Log appends r to the log, setting its Time and Command if they're empty.
+// Other cu-sts processes are kept out by a lock on path.lock while the log is
+// rotated and appended to.
+func Log(r Record) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	if r.Command == "" {
+		r.Command = command
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	lock, err := filelock.Acquire(path+".lock", lockTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %v", path, err)
+	}
+	defer filelock.Release(lock)
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > maxSize {
+		if err = rotate(); err != nil {
+			return fmt.Errorf("unable to rotate %s: %v", path, err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate shifts the backups and moves the log to path.1.
+func rotate() error {
+	name := func(i int) string {
+		return fmt.Sprintf("%s.%d", path, i)
+	}
+	if backups < 1 {
+		return os.Remove(path)
+	}
+	os.Remove(name(backups))
+	for i := backups - 1; i >= 1; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, name(1))
+}
+
+// Records returns the records in the log at p and its backups, oldest first.
+// Lines that aren't records are skipped.
+func Records(p string, keep int) ([]Record, error) {
+	var files []string
+	for i := keep; i >= 1; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", p, i))
+	}
+	files = append(files, p)
+
+	var records []Record
+	for _, name := range files {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r Record
+			if json.Unmarshal(scanner.Bytes(), &r) == nil && !r.Time.IsZero() {
+				records = append(records, r)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"cu-sts/audit"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditSince       string
+	auditUntil       string
+	auditAccessKeyID string
+	auditCommandName string
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Lists the credentials cu-sts issued, from the audit log.",
+	Long: `Lists the records cu-sts appends to its audit log each time STS issues
+credentials, oldest first, including the rotated files. --profiles, --account
+(ID or alias), --role and --username filter them, as do the flags below.
+Match them against CloudTrail by access key ID or assumed-role ARN.`,
+	Run: auditCommand,
+	// Reading the audit log doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "only records after this RFC 3339 time, date (2006-01-02) or duration ago (24h)")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "only records before this RFC 3339 time, date or duration ago")
+	auditCmd.Flags().StringVar(&auditAccessKeyID, "access-key-id", "", "only the record of this access key ID")
+	auditCmd.Flags().StringVar(&auditCommandName, "command", "", "only records of this cu-sts command, e.g. exec or creds")
+}
+
+func auditCommand(cmd *cobra.Command, args []string) {
+	since, err := parseAuditTime(auditSince)
+	if err != nil {
+		usageError(fmt.Sprintf("invalid --since: %v", err))
+	}
+	until, err := parseAuditTime(auditUntil)
+	if err != nil {
+		usageError(fmt.Sprintf("invalid --until: %v", err))
+	}
+	names := profilesFlag
+	if singleProfileFlag != "" {
+		names = append(names, singleProfileFlag)
+	}
+
+	path, keep, err := auditFile()
+	if err != nil {
+		fatalError(err.Error())
+	}
+	all, err := audit.Records(path, keep)
+	if err != nil {
+		fatalError(fmt.Sprintf("could not read audit log: %v", err))
+	}
+
+	records := []audit.Record{}
+	for _, r := range all {
+		switch {
+		case len(names) > 0 && !contains(names, r.Profile),
+			account != "" && account != r.Account && account != r.Alias,
+			role != "" && role != r.Role,
+			cmd.Flags().Changed("username") && username != r.Username,
+			auditAccessKeyID != "" && auditAccessKeyID != r.AccessKeyID,
+			auditCommandName != "" && auditCommandName != r.Command,
+			!since.IsZero() && r.Time.Before(since),
+			!until.IsZero() && r.Time.After(until):
+			continue
+		}
+		records = append(records, r)
+	}
+
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, records)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNETID\tPROFILE\tACCOUNT\tROLE\tACCESS KEY\tEXPIRATION\tCOMMAND")
+	for _, r := range records {
+		accountLabel := r.Account
+		if r.Alias != "" {
+			accountLabel = fmt.Sprintf("%s (%s)", r.Alias, r.Account)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Local().Format(time.RFC3339), r.Username,
+			r.Profile, accountLabel, r.Role, r.AccessKeyID, r.Expiration.Local().Format(time.RFC3339), r.Command)
+	}
+	w.Flush()
+}
+
+// parseAuditTime parses an RFC 3339 time, a local date, or a duration before
+// now. An empty value is the zero time.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Markers around the ~/.aws/config profiles export-aws manages.
+const (
+	exportBegin = "# BEGIN cu-sts managed profiles, regenerate with: cu-sts config export-aws"
+	exportEnd   = "# END cu-sts managed profiles"
+)
+
+var (
+	exportFile         string
+	exportCommand      string
+	exportRegion       string
+	exportOutputFormat string
+)
+
+var sectionHeader = regexp.MustCompile(`^\s*\[\s*([^\]]+?)\s*\]`)
+
+// exportOutput describes the exported profiles for --output json.
+type exportOutput struct {
+	File     string   `json:"file"`
+	Profiles []string `json:"profiles"`
+	Skipped  []string `json:"skipped"`
+	Changed  bool     `json:"changed"`
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Generates configuration for other tools from the config file.",
+	Long:  ``,
+	// Generating config doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+// configExportAWSCmd represents the config export-aws command
+var configExportAWSCmd = &cobra.Command{
+	Use:   "export-aws",
+	Short: "Writes ~/.aws/config profiles that get credentials from cu-sts.",
+	Long: `Writes a [profile NAME] block to the AWS config file for every profile in the
+config file, with a credential_process running cu-sts process and the
+profile's region and output. The blocks are kept between marker comments and
+replaced on every run. Blocks you wrote yourself are left alone, and a
+profile with one is skipped.`,
+	Run: configExportAWSCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configExportAWSCmd)
+
+	configExportAWSCmd.Flags().StringVar(&exportFile, "file", "~/.aws/config", "AWS config file to write")
+	configExportAWSCmd.Flags().StringVar(&exportCommand, "command", "cu-sts", "cu-sts executable the credential_process runs")
+	configExportAWSCmd.Flags().StringVar(&exportRegion, "aws-region", "", "region for profiles that don't set region")
+	configExportAWSCmd.Flags().StringVar(&exportOutputFormat, "aws-output", "", "AWS CLI output format for profiles that don't set output")
+
+	viper.BindPFlag("aws_region", configExportAWSCmd.Flags().Lookup("aws-region"))
+	viper.BindPFlag("aws_output", configExportAWSCmd.Flags().Lookup("aws-output"))
+}
+
+func configExportAWSCommand(cmd *cobra.Command, args []string) {
+	var names []string
+	for name := range profile.Profiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f, err := lockFilePath(exportFile)
+	if err != nil {
+		fatalError(fmt.Sprintf("could not use AWS config file: %v", err))
+	}
+	defer f.Close()
+	data, err := f.Read()
+	if err != nil {
+		f.Close()
+		fatalError(fmt.Sprintf("could not read AWS config file: %v", err))
+	}
+
+	before, after, err := splitManaged(data)
+	if err != nil {
+		f.Close()
+		fatalError(fmt.Sprintf("could not update %s: %v", f.Path, err))
+	}
+	userSections := make(map[string]bool)
+	for _, line := range append(before, after...) {
+		if m := sectionHeader.FindStringSubmatch(line); m != nil {
+			userSections[strings.Join(strings.Fields(m[1]), " ")] = true
+		}
+	}
+
+	out := exportOutput{File: f.Path, Profiles: []string{}, Skipped: []string{}}
+	var block []string
+	for _, name := range names {
+		p, err := profile.NewFromConfig(name)
+		if err != nil {
+			f.Close()
+			fatalErr(err, "unable to load profile")
+		}
+		section := iniFormat{config: true}.section(name)
+		if userSections[section] {
+			logging.Warnf("Skipping %s, %s already has a [%s] block.", name, f.Path, section)
+			out.Skipped = append(out.Skipped, name)
+			continue
+		}
+		if len(block) > 0 {
+			block = append(block, "")
+		}
+		block = append(block, exportBlock(&p, section)...)
+		out.Profiles = append(out.Profiles, name)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range before {
+		fmt.Fprintln(&buf, line)
+	}
+	if len(block) > 0 {
+		if len(before) > 0 && strings.TrimSpace(before[len(before)-1]) != "" {
+			fmt.Fprintln(&buf)
+		}
+		fmt.Fprintln(&buf, exportBegin)
+		for _, line := range block {
+			fmt.Fprintln(&buf, line)
+		}
+		fmt.Fprintln(&buf, exportEnd)
+	}
+	for _, line := range after {
+		fmt.Fprintln(&buf, line)
+	}
+
+	if out.Changed = !bytes.Equal(buf.Bytes(), data); out.Changed {
+		if err = f.Replace(buf.Bytes()); err != nil {
+			f.Close()
+			fatalError(fmt.Sprintf("could not save %s: %v", f.Path, err))
+		}
+		logging.Infof("Wrote %d profiles to %s.", len(out.Profiles), f.Path)
+	} else {
+		logging.Infof("%s is up to date.", f.Path)
+	}
+
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, out)
+	}
+}
+
+// splitManaged returns the lines of data before and after the managed block,
+// without the block.
+func splitManaged(data []byte) (before, after []string, err error) {
+	begin, end := -1, -1
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# BEGIN cu-sts") && begin < 0:
+			begin = len(lines)
+		case strings.HasPrefix(line, exportEnd) && begin >= 0 && end < 0:
+			end = len(lines)
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case begin < 0:
+		return lines, nil, nil
+	case end < 0:
+		return nil, nil, fmt.Errorf("missing %q after line %d", exportEnd, begin+1)
+	}
+	return lines[:begin], lines[end+1:], nil
+}
+
+// exportBlock returns the lines of p's managed [section] block.
+func exportBlock(p *profile.Profile, section string) []string {
+	command := []string{exportCommand, "process", "--quiet", "--profile", p.Name}
+	if cfgFile != "" {
+		abs, _ := filepath.Abs(cfgFile)
+		command = append(command, "--config", abs)
+	}
+	for i, arg := range command {
+		if strings.ContainsAny(arg, " \t\"") {
+			command[i] = fmt.Sprintf("%q", arg)
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("[%s]", section),
+		fmt.Sprintf("credential_process = %s", strings.Join(command, " ")),
+	}
+	region, output := p.Region, p.Output
+	if region == "" {
+		region = viper.GetString("aws_region")
+	}
+	if output == "" {
+		output = viper.GetString("aws_output")
+	}
+	if region != "" {
+		lines = append(lines, fmt.Sprintf("region = %s", region))
+	}
+	if output != "" {
+		lines = append(lines, fmt.Sprintf("output = %s", output))
+	}
+	return lines
+}
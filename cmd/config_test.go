@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitManaged(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantBefore []string
+		wantAfter  []string
+		wantErr    string
+	}{
+		{
+			name:       "no block",
+			data:       "[default]\nregion = us-east-1\n",
+			wantBefore: []string{"[default]", "region = us-east-1"},
+		},
+		{
+			name:       "block",
+			data:       "[default]\n" + exportBegin + "\n[profile dev]\n" + exportEnd + "\n[profile late]\n",
+			wantBefore: []string{"[default]"},
+			wantAfter:  []string{"[profile late]"},
+		},
+		{
+			name:       "older begin line",
+			data:       "# BEGIN cu-sts managed profiles\n[profile dev]\n" + exportEnd + "\n",
+			wantBefore: []string{},
+			wantAfter:  []string{},
+		},
+		{
+			name:    "missing end",
+			data:    "[default]\n" + exportBegin + "\n[profile dev]\n",
+			wantErr: "missing",
+		},
+		{
+			name:       "end without begin",
+			data:       exportEnd + "\n[default]\n",
+			wantBefore: []string{exportEnd, "[default]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after, err := splitManaged([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("splitManaged() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitManaged() error = %v", err)
+			}
+			if !reflect.DeepEqual(before, tt.wantBefore) {
+				t.Errorf("splitManaged() before = %q, want %q", before, tt.wantBefore)
+			}
+			if !reflect.DeepEqual(after, tt.wantAfter) {
+				t.Errorf("splitManaged() after = %q, want %q", after, tt.wantAfter)
+			}
+		})
+	}
+}
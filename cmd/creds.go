@@ -1,21 +1,44 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"cu-sts/idp"
+	"cu-sts/logging"
 	"cu-sts/profile"
 
-	"github.com/fatih/color"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"gopkg.in/ini.v1"
 )
 
 var outFile string
 var outProfile string
-var outCfg *ini.File
+var refreshWithin time.Duration
+var targetsFlag []string
+
+// credsTargets are the targets used by the selected profiles, by name.
+var credsTargets map[string]*credsTarget
+
+// outExpirations are the selected profiles' current expirations, the earliest
+// across their targets.
+var outExpirations map[string]time.Time
+
+// credsOutput describes a written profile for --output json.
+type credsOutput struct {
+	Profile    string    `json:"profile"`
+	Account    string    `json:"account"`
+	Alias      string    `json:"alias,omitempty"`
+	Role       string    `json:"role"`
+	Target     string    `json:"target"`
+	Name       string    `json:"name"`
+	File       string    `json:"file"`
+	Expiration time.Time `json:"expiration"`
+}
 
 // credsCmd represents the creds command
 var credsCmd = &cobra.Command{
@@ -31,6 +54,9 @@ func init() {
 
 	credsCmd.Flags().StringVar(&outFile, "out-file", "~/.aws/credentials", "file to write credentials to")
 	credsCmd.Flags().StringVar(&outProfile, "out-profile", "saml", "name to write single credentials to")
+	credsCmd.Flags().DurationVar(&refreshWithin, "refresh-within", 0, "only refresh profiles that are expired or expire within this duration, e.g. 15m")
+	credsCmd.Flags().StringSliceVar(&targetsFlag, "targets", nil, "[target.NAME] config sections to write to instead of the profiles' targets")
+	credsCmd.RegisterFlagCompletionFunc("targets", completeTargets)
 }
 
 func validateCredsArgs(cmd *cobra.Command, args []string) {
@@ -39,62 +65,192 @@ func validateCredsArgs(cmd *cobra.Command, args []string) {
 
 	if len(profilesFlag) == 0 {
 		p.Name = outProfile
-		p.Account = account
+		if p.Account, err = profile.ResolveAccount(account); err != nil {
+			usageError(err.Error())
+		}
+		p.Alias = profile.Alias(p.Account)
 		p.Role = role
 		p.IDProvider = viper.GetString("id_provider")
 		p.Duration = viper.GetInt("duration")
+		applyIdentityFlags(&p)
 		profiles = append(profiles, p)
 	} else {
 		for _, k := range profilesFlag {
 			if p, err = profile.NewFromConfig(k); err != nil {
-				fatalError(err.Error())
+				fatalErr(err, "unable to load profile")
 			}
+			applyIdentityFlags(&p)
 			profiles = append(profiles, p)
 		}
 	}
 
-	if outFile != `~/.aws/credentials` {
-		outCfg, err = ini.Load(outFile)
-	} else {
-		outFile, _ = homedir.Expand(outFile)
-		outCfg, err = ini.Load(outFile)
+	outFile, _ = homedir.Expand(outFile)
+	planned, err := loadTargets(profiles)
+	if err != nil {
+		usageError(err.Error())
 	}
+
+	// only read here to check the files, creds locks them to write
+	files, err := planTargets(planned)
 	if err != nil {
-		fatalError(fmt.Sprintf("could not use out-file: %v", err))
+		usageError(fmt.Sprintf("invalid targets: %v", err))
+	}
+	if outExpirations, err = targetExpirations(files); err != nil {
+		usageError(fmt.Sprintf("could not use target: %v", err))
 	}
 }
 
-func credsCommand(cmd *cobra.Command, args []string) {
-	var username = viper.GetString("username")
-	var password = viper.GetString("password")
-	var duoMethod = viper.GetString("duo_method")
+// identityLogin is the result of logging in as one identity.
+type identityLogin struct {
+	SAMLResponse string
+	Err          error
+}
 
-	var SAMLResponse string
-	if err := idp.GetSAMLResponse(username, password, duoMethod, debug, &SAMLResponse); err != nil {
-		fatalError(fmt.Sprintf("failed to fetch credentials via IdP: %v\n", err))
+// loginIdentities logs in once per identity used by profiles, concurrently
+// unless the user must finish each login in a visible browser.
+func loginIdentities(profiles []profile.Profile) map[string]*identityLogin {
+	logins := make(map[string]*identityLogin)
+	var order []profile.Profile
+	for _, p := range profiles {
+		if _, ok := logins[p.Identity()]; !ok {
+			logins[p.Identity()] = &identityLogin{}
+			order = append(order, p)
+		}
 	}
 
-	fmt.Printf("Writing credentials to %s.\n", outFile)
+	concurrent := len(order) > 1 && viper.GetString("browser") != idp.BrowserVisible
+
+	ctxt, stop := loginContext()
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, p := range order {
+		p := p
+		login := logins[p.Identity()]
+		run := func() {
+			if len(order) > 1 {
+				logging.Infof("Logging in as %s.", p.Identity())
+			}
+			login.SAMLResponse, login.Err = newClient(&p).SAMLResponse(ctxt, p.Username)
+		}
+		if !concurrent {
+			run()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
+	wg.Wait()
+	return logins
+}
 
+// expiring returns the profiles whose credentials in any of their targets are
+// missing, expired or expire within window.
+func expiring(profiles []profile.Profile, window time.Duration) []profile.Profile {
+	var selected []profile.Profile
 	for _, p := range profiles {
-		creds, err := p.Credentials(SAMLResponse)
+		expiration := outExpirations[p.Name]
+		if remaining := time.Until(expiration); remaining > window {
+			logging.Debugf("Profile %s is valid for %v, not refreshing.", p.Name, remaining.Truncate(time.Second))
+			continue
+		}
+		selected = append(selected, p)
+	}
+	return selected
+}
+
+func credsCommand(cmd *cobra.Command, args []string) {
+	selected := profiles
+	if cmd.Flags().Changed("refresh-within") {
+		if selected = expiring(profiles, refreshWithin); len(selected) == 0 {
+			logging.Infof("All profiles are valid for at least %v, nothing to refresh.", refreshWithin)
+			if outputFormat == outputJSON {
+				writeJSON(os.Stdout, []credsOutput{})
+			}
+			return
+		}
+	}
+
+	for i := range selected {
+		confirmProtected(&selected[i])
+	}
+	for i := range selected {
+		runHooks(hookPreLogin, &selected[i], nil)
+	}
+	logins := loginIdentities(selected)
+
+	var results []profileCreds
+	var failure error
+	var failureMsg string
+	for _, p := range selected {
+		login := logins[p.Identity()]
+		if login.Err != nil {
+			logging.Warnf("Skipping %s, login as %s failed: %v", p.Name, p.Identity(), login.Err)
+			if failure == nil {
+				failure, failureMsg = login.Err, "failed to fetch credentials via IdP"
+			}
+			continue
+		}
+
+		creds, err := newClient(&p).Exchange(context.Background(), &p, login.SAMLResponse)
 		if err != nil {
-			fatalError(fmt.Sprintf("could net fetch STS credentials: %v.\n", err))
+			logging.Warnf("Skipping %s, could not fetch STS credentials: %v", p.Name, err)
+			if failure == nil {
+				failure, failureMsg = err, "could not fetch STS credentials"
+			}
+			continue
 		}
 
-		fmt.Printf("Received AWS STS credentials for %s, writing to file.\n", p.Name)
+		lookupAlias(&p, creds)
+		logging.Infof("Received AWS STS credentials for %s (%s).", p.Name, p.Label())
+		results = append(results, profileCreds{p, creds})
+	}
 
-		sect := outCfg.Section(p.Name)
-		// Clear any current keys to make sure we don't accidentaly carry over anything extra
-		for _, k := range sect.KeyStrings() {
-			sect.DeleteKey(k)
+	var written []credsOutput
+	files, err := planTargets(results)
+	if err != nil {
+		fatalError(fmt.Sprintf("invalid targets: %v", err))
+	}
+	for _, f := range files {
+		logging.Infof("Writing credentials to %s.", f.Path)
+		if err := writeTargetFile(f); err != nil {
+			logging.Warnf("Could not save credentials to %s: %v", f.Path, err)
+			if failure == nil {
+				failure, failureMsg = err, fmt.Sprintf("could not save credentials to %s", f.Path)
+			}
+			continue
 		}
-		sect.Key("aws_access_key_id").SetValue(*creds.AccessKeyId)
-		sect.Key("aws_secret_access_key").SetValue(*creds.SecretAccessKey)
-		sect.Key("aws_session_token").SetValue(*creds.SessionToken)
-		sect.Key("aws_security_token").SetValue(*creds.SessionToken)
-		if err = outCfg.SaveTo(outFile); err != nil {
-			color.Yellow(fmt.Sprintf("Problem saving profile: %v", err))
+		for _, e := range f.Entries {
+			written = append(written, credsOutput{
+				Profile:    e.Profile.Name,
+				Account:    e.Profile.Account,
+				Alias:      e.Profile.Alias,
+				Role:       e.Profile.Role,
+				Target:     e.Target.Name,
+				Name:       e.Name,
+				File:       f.Path,
+				Expiration: *e.Creds.Expiration,
+			})
 		}
 	}
+
+	saved := make(map[string]bool)
+	for _, w := range written {
+		saved[w.Profile] = true
+	}
+	for _, r := range results {
+		if saved[r.p.Name] {
+			runHooks(hookPostCredentials, &r.p, r.creds)
+		}
+	}
+
+	if failure != nil {
+		fatalErr(failure, failureMsg)
+	}
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, written)
+	}
 }
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cu-sts/filelock"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// credsBackups is how many previous versions of the credentials file are
+	// kept, as <file>.cu-sts.bak.1 (newest) to .3.
+	credsBackups = 3
+	// lockTimeout is how long to wait for another cu-sts to release the file.
+	lockTimeout = 30 * time.Second
+)
+
+// A lockedFile is a file locked for a read-modify-write. Other cu-sts
+// processes wait for the lock, and Replace swaps the file atomically so
+// readers never see a partial write.
+type lockedFile struct {
+	Path string
+	lock *os.File
+}
+
+// A credsFile is a locked AWS credentials or config file.
+type credsFile struct {
+	*lockedFile
+	Cfg *ini.File
+}
+
+// readIfExists returns the contents of the file at path, or nil if it doesn't
+// exist.
+func readIfExists(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// openCredsFile locks and reads the credentials file at path, creating its
+// directory if needed. Close must be called to release the lock.
+func openCredsFile(path string) (*credsFile, error) {
+	f, err := lockFilePath(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.LooseLoad(f.Path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &credsFile{lockedFile: f, Cfg: cfg}, nil
+}
+
+// Save replaces the file with Cfg.
+func (f *credsFile) Save() error {
+	var buf bytes.Buffer
+	if _, err := f.Cfg.WriteTo(&buf); err != nil {
+		return err
+	}
+	return f.Replace(buf.Bytes())
+}
+
+// lockFilePath locks the file at path, which needn't exist, creating its
+// directory if needed. Close must be called to release the lock.
+func lockFilePath(path string) (*lockedFile, error) {
+	path, _ = homedir.Expand(path)
+	// replace a symlink's target, not the link
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	lock, err := filelock.Acquire(path+".cu-sts.lock", lockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock %s: %v", path, err)
+	}
+	return &lockedFile{Path: path, lock: lock}, nil
+}
+
+// Read returns the file's current contents, or nil if it doesn't exist yet.
+func (f *lockedFile) Read() ([]byte, error) {
+	return readIfExists(f.Path)
+}
+
+// Replace backs up the current file and replaces it with data, readable only
+// by the user.
+func (f *lockedFile) Replace(data []byte) error {
+	if err := f.backup(); err != nil {
+		return fmt.Errorf("unable to back up %s: %v", f.Path, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0600); err == nil {
+		if _, err = tmp.Write(data); err == nil {
+			err = tmp.Sync()
+		}
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// Remove backs up the current file and removes it.
+func (f *lockedFile) Remove() error {
+	if err := f.backup(); err != nil {
+		return fmt.Errorf("unable to back up %s: %v", f.Path, err)
+	}
+	if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveBackups deletes the file's backups, which still hold credentials
+// that were removed from it.
+func (f *lockedFile) RemoveBackups() error {
+	for i := 1; i <= credsBackups; i++ {
+		if err := os.Remove(f.backupName(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupName returns the path of the i'th newest backup.
+func (f *lockedFile) backupName(i int) string {
+	return fmt.Sprintf("%s.cu-sts.bak.%d", f.Path, i)
+}
+
+// backup rotates the previous backups and copies the current file to the
+// newest one.
+func (f *lockedFile) backup() error {
+	src, err := os.Open(f.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	for i := credsBackups - 1; i > 0; i-- {
+		if err = os.Rename(f.backupName(i), f.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	dst, err := os.OpenFile(f.backupName(1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Close releases the lock.
+func (f *lockedFile) Close() error {
+	return filelock.Release(f.lock)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLockedFileReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cu-sts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "aws", "credentials")
+
+	// each write backs up the previous version, keeping credsBackups of them
+	writes := []string{"v1", "v2", "v3", "v4", "v5"}
+	for _, data := range writes {
+		f, err := lockFilePath(path)
+		if err != nil {
+			t.Fatalf("lockFilePath() error = %v", err)
+		}
+		err = f.Replace([]byte(data))
+		f.Close()
+		if err != nil {
+			t.Fatalf("Replace(%s) error = %v", data, err)
+		}
+	}
+
+	f := &lockedFile{Path: path}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path, "v5"},
+		{f.backupName(1), "v4"},
+		{f.backupName(2), "v3"},
+		{f.backupName(3), "v2"},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			got, err := ioutil.ReadFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s = %q, want %q", tt.path, got, tt.want)
+			}
+			if runtime.GOOS == "windows" {
+				return
+			}
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("%s mode = %v, want 0600", tt.path, mode)
+			}
+		})
+	}
+	if _, err := os.Stat(f.backupName(credsBackups + 1)); !os.IsNotExist(err) {
+		t.Errorf("backup %d exists, want only %d", credsBackups+1, credsBackups)
+	}
+
+	// no temporary files are left next to the file
+	names, err := filepath.Glob(filepath.Join(dir, "aws", ".credentials.tmp*"))
+	if err != nil || len(names) > 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+
+	if err := f.RemoveBackups(); err != nil {
+		t.Fatalf("RemoveBackups() error = %v", err)
+	}
+	for i := 1; i <= credsBackups; i++ {
+		if _, err := os.Stat(f.backupName(i)); !os.IsNotExist(err) {
+			t.Errorf("backup %d still exists", i)
+		}
+	}
+}
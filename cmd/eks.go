@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cu-sts/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	eksTokenPrefix    = "k8s-aws-v1."
+	eksClusterHeader  = "x-k8s-aws-id"
+	execCredentialAPI = "client.authentication.k8s.io/v1beta1"
+	// EKS accepts presigned tokens for 15 minutes, refresh a minute early
+	eksTokenLifetime = 14 * time.Minute
+)
+
+var (
+	eksCluster    string
+	eksRegion     string
+	eksKubeconfig string
+	eksUser       string
+)
+
+// execCredential is the client.authentication.k8s.io ExecCredential printed
+// for kubectl.
+type execCredential struct {
+	Kind       string               `json:"kind"`
+	APIVersion string               `json:"apiVersion"`
+	Spec       struct{}             `json:"spec"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+	Token               string    `json:"token"`
+}
+
+// eksCmd represents the eks command
+var eksCmd = &cobra.Command{
+	Use:   "eks",
+	Short: "Authenticates to EKS clusters with STS credentials.",
+	Long:  ``,
+}
+
+// eksTokenCmd represents the eks token command
+var eksTokenCmd = &cobra.Command{
+	Use:    "token",
+	Short:  "Prints an EKS bearer token as a kubectl ExecCredential.",
+	Long:   ``,
+	Run:    eksTokenCommand,
+	PreRun: validateSingleProfileArgs,
+}
+
+// eksKubeconfigCmd represents the eks kubeconfig command
+var eksKubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "Adds a kubeconfig user that gets its token from cu-sts eks token.",
+	Long:  ``,
+	Run:   eksKubeconfigCommand,
+	// Writing the user entry doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(eksCmd)
+	eksCmd.AddCommand(eksTokenCmd)
+	eksCmd.AddCommand(eksKubeconfigCmd)
+
+	eksCmd.PersistentFlags().StringVar(&eksCluster, "cluster", "", "name of the EKS cluster")
+	eksCmd.PersistentFlags().StringVar(&eksRegion, "region", "us-east-1", "region of the STS endpoint used for the token")
+	eksTokenCmd.Flags().DurationVar(&processRefresh, "refresh-within", processRefreshDefault, "log in again when the cached credentials expire within this duration")
+	eksKubeconfigCmd.Flags().StringVar(&eksKubeconfig, "kubeconfig", "~/.kube/config", "kubeconfig file to add the user to")
+	eksKubeconfigCmd.Flags().StringVar(&eksUser, "user", "", "name of the kubeconfig user (default is cu-sts-<profile>-<cluster>)")
+}
+
+func eksTokenCommand(cmd *cobra.Command, args []string) {
+	if eksCluster == "" {
+		usageError("--cluster is required.")
+	}
+	p := profiles[0]
+	// kubectl runs eks token for every command, so reuse the credentials
+	// process caches rather than logging in each time, and like process it
+	// runs no hooks
+	sdkCommand = true
+	creds := cachedCredentials(&p, processRefresh)
+
+	token, err := eksToken(creds, eksCluster, eksRegion)
+	if err != nil {
+		fatalErr(err, "could not presign EKS token")
+	}
+
+	expiration := time.Now().Add(eksTokenLifetime)
+	if creds.Expiration != nil && creds.Expiration.Before(expiration) {
+		expiration = *creds.Expiration
+	}
+
+	cred := execCredential{Kind: "ExecCredential", APIVersion: execCredentialAPI}
+	cred.Status.Token = token
+	cred.Status.ExpirationTimestamp = expiration.UTC()
+	logging.Infof("Received EKS token for %s on %s.", p.Name, eksCluster)
+	writeJSON(os.Stdout, cred)
+}
+
+// eksToken presigns an sts:GetCallerIdentity request for cluster, which EKS
+// verifies to authenticate the caller.
+func eksToken(creds *sts.Credentials, cluster, region string) (string, error) {
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithCredentials(credentials.NewStaticCredentials(
+			aws.StringValue(creds.AccessKeyId),
+			aws.StringValue(creds.SecretAccessKey),
+			aws.StringValue(creds.SessionToken),
+		)))
+	if err != nil {
+		return "", err
+	}
+
+	req, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.HTTPRequest.Header.Add(eksClusterHeader, cluster)
+	presigned, err := req.Presign(60 * time.Second)
+	if err != nil {
+		return "", err
+	}
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned)), nil
+}
+
+func eksKubeconfigCommand(cmd *cobra.Command, args []string) {
+	if singleProfileFlag == "" || eksCluster == "" {
+		usageError("--profile and --cluster are required.")
+	}
+	if eksUser == "" {
+		eksUser = fmt.Sprintf("cu-sts-%s-%s", singleProfileFlag, eksCluster)
+	}
+	path, _ := homedir.Expand(eksKubeconfig)
+
+	if err := addKubeconfigUser(path, eksUser, kubeconfigExec(singleProfileFlag, eksCluster, eksRegion)); err != nil {
+		fatalError(fmt.Sprintf("could not update kubeconfig: %v", err))
+	}
+	logging.Infof("Added user %s to %s. Use it with:", eksUser, path)
+	logging.Infof("  kubectl config set-context --current --user=%s", eksUser)
+}
+
+// kubeconfigExec returns the exec section of a kubeconfig user running
+// cu-sts eks token.
+func kubeconfigExec(profileName, cluster, region string) yaml.MapSlice {
+	command, err := os.Executable()
+	if err != nil {
+		command = "cu-sts"
+	}
+	execArgs := []string{"eks", "token", "--quiet", "--profile", profileName, "--cluster", cluster, "--region", region}
+	if cfgFile != "" {
+		abs, _ := filepath.Abs(cfgFile)
+		execArgs = append(execArgs, "--config", abs)
+	}
+	return yaml.MapSlice{
+		{Key: "apiVersion", Value: execCredentialAPI},
+		{Key: "command", Value: command},
+		{Key: "args", Value: execArgs},
+		// the login may need a password and DUO
+		{Key: "interactiveMode", Value: "IfAvailable"},
+	}
+}
+
+// addKubeconfigUser adds or replaces the named user in the kubeconfig at path,
+// keeping the rest of the file. Like the credentials file, it is locked and
+// replaced atomically with a backup, so concurrent runs don't lose users.
+func addKubeconfigUser(path, name string, exec yaml.MapSlice) error {
+	f, err := lockFilePath(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config := yaml.MapSlice{
+		{Key: "apiVersion", Value: "v1"},
+		{Key: "kind", Value: "Config"},
+	}
+	data, err := f.Read()
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	user := yaml.MapSlice{
+		{Key: "name", Value: name},
+		{Key: "user", Value: yaml.MapSlice{{Key: "exec", Value: exec}}},
+	}
+
+	usersAt := -1
+	for i, item := range config {
+		if item.Key == "users" {
+			usersAt = i
+		}
+	}
+	if usersAt < 0 {
+		config = append(config, yaml.MapItem{Key: "users", Value: []interface{}{}})
+		usersAt = len(config) - 1
+	}
+	users, _ := config[usersAt].Value.([]interface{})
+
+	replaced := false
+	for i, u := range users {
+		if entry, ok := u.(yaml.MapSlice); ok && mapValue(entry, "name") == name {
+			users[i] = user
+			replaced = true
+		}
+	}
+	if !replaced {
+		users = append(users, user)
+	}
+	config[usersAt].Value = users
+
+	if data, err = yaml.Marshal(config); err != nil {
+		return err
+	}
+	return f.Replace(data)
+}
+
+func mapValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
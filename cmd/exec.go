@@ -5,12 +5,15 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"cu-sts/idp"
+	"cu-sts/logging"
 	"cu-sts/profile"
 
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -20,74 +23,127 @@ var (
 	subArgs []string
 )
 
+// execOutput describes the spawned sub-command for --output json.
+type execOutput struct {
+	Profile    string    `json:"profile"`
+	Account    string    `json:"account"`
+	Alias      string    `json:"alias,omitempty"`
+	Role       string    `json:"role"`
+	Command    []string  `json:"command"`
+	Expiration time.Time `json:"expiration"`
+}
+
 // execCmd represents the exec command
 var execCmd = &cobra.Command{
 	Use:    "exec",
 	Short:  "Execute a command or spawn a shell with new AWS STS credentials.",
 	Long:   ``,
 	Run:    execCommand,
-	PreRun: validateExecArgs,
+	PreRun: validateSingleProfileArgs,
 }
 
 func init() {
 	rootCmd.AddCommand(execCmd)
 }
 
-func validateExecArgs(cmd *cobra.Command, args []string) {
+// validateSingleProfileArgs loads the single profile, from the config file or
+// ad-hoc flags, used by commands like exec.
+func validateSingleProfileArgs(cmd *cobra.Command, args []string) {
 	var err error
 	profilesCount := len(profilesFlag)
 	p := profile.New()
 
 	if profilesCount > 1 {
-		fatalError("exec command can only use a single --profile argument.")
+		usageError(fmt.Sprintf("%s command can only use a single --profile argument.", cmd.Name()))
 	}
 	if profilesCount == 0 {
-		p.Account = account
+		if p.Account, err = profile.ResolveAccount(account); err != nil {
+			usageError(err.Error())
+		}
+		p.Alias = profile.Alias(p.Account)
 		p.Role = role
-		p.Name = fmt.Sprintf("%s/%s", p.Account, p.Role)
+		p.Name = fmt.Sprintf("%s/%s", p.Label(), p.Role)
 		p.IDProvider = viper.GetString("id_provider")
 		p.Duration = viper.GetInt("duration")
 	} else {
 		if p, err = profile.NewFromConfig(profilesFlag[0]); err != nil {
-			fatalError(err.Error())
+			fatalErr(err, "unable to load profile")
 		}
 	}
+	applyIdentityFlags(&p)
 
 	profiles = append(profiles, p)
 }
 
-func execCommand(cmd *cobra.Command, args []string) {
-	var username = viper.GetString("username")
-	var password = viper.GetString("password")
-	var duoMethod = viper.GetString("duo_method")
+// staleEnv are the variables cleared before a profile's credentials are set
+// in an environment.
+var staleEnv = []string{
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_FILE", "AWS_DEFAULT_PROFILE", "AWS_PROFILE",
+	"CUSTS_PROFILE", "CUSTS_ACCOUNT_ALIAS", "CUSTS_ACCOUNT", "CUSTS_ROLE", "CUSTS_ROLE_ARN", "CUSTS_EXPIRATION",
+	"CUSTS_PROTECTED",
+}
 
-	p := profiles[0]
+// credentialsEnv returns the variables, in order, that give an environment
+// p's credentials.
+func credentialsEnv(p *profile.Profile, creds *sts.Credentials) [][2]string {
+	env := [][2]string{
+		{"AWS_ACCESS_KEY_ID", *creds.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", *creds.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", *creds.SessionToken},
+		{"AWS_SECURITY_TOKEN", *creds.SessionToken},
+	}
+	env = append(env, profileEnv(p)...)
+	return append(env,
+		[2]string{"CUSTS_ROLE_ARN", p.AssumedRoleARN},
+		[2]string{"CUSTS_EXPIRATION", creds.Expiration.UTC().Format(time.RFC3339)},
+	)
+}
 
-	var SAMLResponse string
-	if err := idp.GetSAMLResponse(username, password, duoMethod, debug, &SAMLResponse); err != nil {
-		fatalError(fmt.Sprintf("failed to fetch credentials via IdP %v\n", err))
-		os.Exit(1)
+// profileEnv returns the CUSTS_* variables describing p, without its
+// credentials.
+func profileEnv(p *profile.Profile) [][2]string {
+	env := [][2]string{
+		{"CUSTS_PROFILE", p.Name},
+		{"CUSTS_ACCOUNT", p.Account},
+		{"CUSTS_ROLE", p.Role},
+	}
+	if p.Alias != "" {
+		env = append(env, [2]string{"CUSTS_ACCOUNT_ALIAS", p.Alias})
 	}
+	if p.Protected {
+		env = append(env, [2]string{"CUSTS_PROTECTED", "1"})
+	}
+	return env
+}
 
-	creds, err := p.Credentials(SAMLResponse)
+func execCommand(cmd *cobra.Command, args []string) {
+	p := profiles[0]
+	confirmProtected(&p)
+	runHooks(hookPreLogin, &p, nil)
+
+	ctxt, stop := loginContext()
+	creds, err := newClient(&p).Credentials(ctxt, &p)
+	stop()
 	if err != nil {
-		fatalError(fmt.Sprintf("could net fetch STS credentials %v.\n", err))
+		fatalErr(err, "failed to fetch credentials")
 	}
 
-	fmt.Printf("Received AWS STS credentials for %s, spawning sub-command.\n", p.Name)
+	lookupAlias(&p, creds)
+	if len(profilesFlag) == 0 {
+		p.Name = fmt.Sprintf("%s/%s", p.Label(), p.Role)
+	}
+
+	runHooks(hookPostCredentials, &p, creds)
+	logging.Infof("Received AWS STS credentials for %s, spawning sub-command.", p.Name)
 
 	env := environ(os.Environ())
-	env.Unset("AWS_ACCESS_KEY_ID")
-	env.Unset("AWS_SECRET_ACCESS_KEY")
-	env.Unset("AWS_CREDENTIAL_FILE")
-	env.Unset("AWS_DEFAULT_PROFILE")
-	env.Unset("AWS_PROFILE")
-
-	env.Set("AWS_ACCESS_KEY_ID", *creds.AccessKeyId)
-	env.Set("AWS_SECRET_ACCESS_KEY", *creds.SecretAccessKey)
-	env.Set("AWS_SESSION_TOKEN", *creds.SessionToken)
-	env.Set("AWS_SECURITY_TOKEN", *creds.SessionToken)
-	env.Set("CUSTS_PROFILE", p.Name)
+	for _, name := range staleEnv {
+		env.Unset(name)
+	}
+	for _, kv := range credentialsEnv(&p, creds) {
+		env.Set(kv[0], kv[1])
+	}
 
 	subCmd = os.Getenv("SHELL")
 	subArgs = nil
@@ -96,6 +152,18 @@ func execCommand(cmd *cobra.Command, args []string) {
 		subArgs = args[1:]
 	}
 
+	if outputFormat == outputJSON {
+		// STDOUT belongs to the sub-command, so metadata goes to STDERR.
+		writeJSON(os.Stderr, execOutput{
+			Profile:    p.Name,
+			Account:    p.Account,
+			Alias:      p.Alias,
+			Role:       p.Role,
+			Command:    append([]string{subCmd}, subArgs...),
+			Expiration: *creds.Expiration,
+		})
+	}
+
 	sh := exec.Command(subCmd, subArgs...)
 	sh.Env = env
 	sh.Stdin = os.Stdin
@@ -108,7 +176,7 @@ func execCommand(cmd *cobra.Command, args []string) {
 
 	// Apologies to 99designs
 	// https://github.com/99designs/aws-vault/blob/master/cli/exec.go
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	waitCh := make(chan error, 1)
 	go func() {
@@ -123,14 +191,17 @@ func execCommand(cmd *cobra.Command, args []string) {
 				fatalError(err.Error())
 			}
 		case err := <-waitCh:
-			var waitStatus syscall.WaitStatus
+			status := 0
 			if exitError, ok := err.(*exec.ExitError); ok {
-				waitStatus = exitError.Sys().(syscall.WaitStatus)
-				os.Exit(waitStatus.ExitStatus())
-			}
-			if err != nil {
+				status = exitError.Sys().(syscall.WaitStatus).ExitStatus()
+			} else if err != nil {
 				fatalError(err.Error())
 			}
+			signal.Stop(signals)
+			runHooks(hookPostExec, &p, creds, [2]string{"CUSTS_EXIT_STATUS", strconv.Itoa(status)})
+			if status != 0 {
+				os.Exit(status)
+			}
 			return
 		}
 	}
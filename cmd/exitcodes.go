@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cu-sts/custs"
+	"cu-sts/idp"
+	"cu-sts/logging"
+	"cu-sts/profile"
+)
+
+// Exit codes are part of the CLI's interface for scripts: existing values must
+// never change, new ones are only appended. Success is 0.
+const (
+	exitError             = 1  // unclassified failure
+	exitUsage             = 2  // invalid flags, config or profile
+	exitPassword          = 3  // missing password or invalid NetID/password
+	exitLoginPage         = 4  // IdP login page unavailable or unrecognized (retryable)
+	exitDuoTimeout        = 5  // DUO frame or request timed out (retryable)
+	exitDuoDenied         = 6  // DUO request denied
+	exitChrome            = 7  // Chrome could not be started
+	exitSAMLTimeout       = 8  // no SAML assertion after DUO (retryable)
+	exitAccessDenied      = 9  // STS AccessDenied assuming the role
+	exitAssertionRejected = 10 // STS rejected the SAML assertion (retryable)
+	exitSTS               = 11 // any other STS failure (retryable)
+	exitFlow              = 12 // login flow failed in a step without a specific error
+	exitNotConfirmed      = 13 // protected profile not confirmed
+	exitHook              = 14 // hook with on_failure = "abort" failed
+)
+
+// exitCodes maps error values from idp, custs and profile to exit codes.
+var exitCodes = []struct {
+	err       error
+	code      int
+	retryable bool
+}{
+	{idp.ErrPasswordRequired, exitPassword, false},
+	{idp.ErrPasswordCommand, exitPassword, false},
+	{idp.ErrInvalidCredentials, exitPassword, false},
+	{idp.ErrLoginPage, exitLoginPage, true},
+	{idp.ErrDuoTimeout, exitDuoTimeout, true},
+	{idp.ErrDuoDenied, exitDuoDenied, false},
+	{idp.ErrChromeStart, exitChrome, false},
+	{idp.ErrSAMLTimeout, exitSAMLTimeout, true},
+	{idp.ErrFlow, exitFlow, false},
+	{custs.ErrInvalidOptions, exitUsage, false},
+	{profile.ErrProfileNotFound, exitUsage, false},
+	{profile.ErrInvalidProfile, exitUsage, false},
+	{profile.ErrAccessDenied, exitAccessDenied, false},
+	{profile.ErrAssertionRejected, exitAssertionRejected, true},
+	{profile.ErrSTS, exitSTS, true},
+	{errNotConfirmed, exitNotConfirmed, false},
+	{errHook, exitHook, false},
+}
+
+// errorOutput is printed by fatalError with --output json.
+type errorOutput struct {
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	Retryable bool   `json:"retryable"`
+}
+
+// exitCode returns the exit code for err and whether retrying may succeed.
+func exitCode(err error) (int, bool) {
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code, e.retryable
+		}
+	}
+	return exitError, false
+}
+
+// fatalError prints message and exits with the generic error code.
+func fatalError(message string) {
+	exit(exitError, false, message)
+}
+
+// usageError prints message and exits with the usage error code.
+func usageError(message string) {
+	exit(exitUsage, false, message)
+}
+
+// fatalErr prints message and err, exiting with the code mapped from err.
+func fatalErr(err error, message string) {
+	code, retryable := exitCode(err)
+	exit(code, retryable, fmt.Sprintf("%s: %v", message, err))
+}
+
+// exit prints message, with secrets redacted like log output, and exits with
+// code.
+func exit(code int, retryable bool, message string) {
+	message = logging.Redact(strings.TrimSpace(message))
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, errorOutput{Error: message, Code: code, Retryable: retryable})
+	}
+	logging.Errorf("%s", message)
+	os.Exit(code)
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"cu-sts/idp"
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// errHook is returned when a hook with on_failure = "abort" fails.
+var errHook = errors.New("hook failed")
+
+// Hook phases, the keys of a [hooks] section.
+const (
+	hookPreLogin        = "pre_login"
+	hookPostCredentials = "post_credentials"
+	hookPostExec        = "post_exec"
+)
+
+// commandName is the running command, e.g. "exec" or "eks token".
+var commandName string
+
+// sdkCommand is set by commands the AWS SDKs or kubectl run rather than the
+// user, like process. They run no hooks and only show protected profiles'
+// banners.
+var sdkCommand bool
+
+// runHooks runs the top-level and p's hooks for phase, in order, with p's
+// CUSTS_* variables and, unless creds is nil, its credentials added to their
+// environment along with extra. A failing hook is logged, or exits with
+// errHook if its on_failure is abort.
+func runHooks(phase string, p *profile.Profile, creds *sts.Credentials, extra ...[2]string) {
+	if sdkCommand {
+		return
+	}
+	// validateRootArgs already checked the top-level hooks
+	global, _ := profile.GlobalHooks()
+	hooks := append(phaseHooks(&global, phase), phaseHooks(&p.Hooks, phase)...)
+	if len(hooks) == 0 {
+		return
+	}
+
+	env := environ(os.Environ())
+	for _, name := range staleEnv {
+		env.Unset(name)
+	}
+	vars := profileEnv(p)
+	if creds != nil {
+		vars = credentialsEnv(p, creds)
+	}
+	vars = append(vars, [2]string{"CUSTS_HOOK", phase}, [2]string{"CUSTS_COMMAND", commandName})
+	for _, kv := range append(vars, extra...) {
+		env.Set(kv[0], kv[1])
+	}
+
+	for _, hook := range hooks {
+		err := runHook(hook, env)
+		if err == nil {
+			continue
+		}
+		if hook.OnFailure == profile.HookAbort {
+			fatalErr(fmt.Errorf("%w: %s hook %q: %v", errHook, phase, hook.Command, err),
+				fmt.Sprintf("not continuing with %s", p.Name))
+		}
+		logging.Warnf("The %s hook %q for %s failed: %v", phase, hook.Command, p.Name, err)
+	}
+}
+
+// phaseHooks returns h's hooks for phase.
+func phaseHooks(h *profile.Hooks, phase string) []profile.Hook {
+	switch phase {
+	case hookPreLogin:
+		return h.PreLogin
+	case hookPostCredentials:
+		return h.PostCredentials
+	case hookPostExec:
+		return h.PostExec
+	}
+	return nil
+}
+
+// runHook runs hook with env. STDOUT may be credentials, JSON or commands for
+// the shell to eval, so the hook prints to STDERR.
+func runHook(hook profile.Hook, env []string) error {
+	timeout := hook.TimeoutDuration()
+	ctxt, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if hook.Shell {
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctxt, "cmd", "/C", hook.Command)
+		} else {
+			cmd = exec.CommandContext(ctxt, "/bin/sh", "-c", hook.Command)
+		}
+	} else {
+		args, err := idp.SplitArgs(hook.Command)
+		if err != nil {
+			return err
+		}
+		cmd = exec.CommandContext(ctxt, args[0], args[1:]...)
+	}
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	logging.Debugf("Running hook %q.", hook.Command)
+	err := cmd.Run()
+	if ctxt.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("exited with status %d", exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cu-sts/idp"
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/ini.v1"
+)
+
+var (
+	logoutFile string
+	logoutAll  bool
+)
+
+// logoutOutput lists the removed profiles for --output json.
+type logoutOutput struct {
+	Removed         []string `json:"removed"`
+	Sessions        bool     `json:"sessions_cleared"`
+	BrowserProfiles bool     `json:"browser_profiles_cleared"`
+}
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Removes credentials written by cu-sts and the stored IdP session.",
+	Long: `Removes the profiles creds wrote to the credentials file and the profiles'
+other targets, either those named by --profiles or, with --all, every one of
+them along with the stored IdP session cookies and browser profiles. The
+process command's cached credentials are removed too, and so are the backups
+of every file credentials were removed from. A profile's sections are found
+by rendering its targets' name templates, as creds does. Profiles cu-sts
+didn't write are never touched.`,
+	Run: logoutCommand,
+	// Logging out doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes expired credentials written by cu-sts.",
+	Long:  ``,
+	Run:   pruneCommand,
+	// Pruning doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(pruneCmd)
+
+	for _, c := range []*cobra.Command{logoutCmd, pruneCmd} {
+		c.Flags().StringVar(&logoutFile, "out-file", "~/.aws/credentials", "credentials file creds writes to")
+	}
+	logoutCmd.Flags().BoolVar(&logoutAll, "all", false, "remove every profile cu-sts wrote, the stored IdP session and the browser profiles")
+}
+
+func logoutCommand(cmd *cobra.Command, args []string) {
+	names := profilesFlag
+	if singleProfileFlag != "" {
+		names = append(names, singleProfileFlag)
+	}
+	if logoutAll == (len(names) > 0) {
+		usageError("must use either --profiles or --all.")
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	files := logoutTargetFiles()
+	sections := sectionProfiles(files)
+	out := logoutOutput{Removed: removeSections(func(sect *ini.Section) bool {
+		return logoutAll || wanted[sectionProfile(sections, sect.Name())]
+	}, true)}
+	for i, name := range out.Removed {
+		out.Removed[i] = sectionProfile(sections, name)
+	}
+	for _, name := range removeFromTargets(files, func(e targetEntry, _ jsonCreds) bool {
+		return logoutAll || wanted[e.Profile.Name]
+	}, true) {
+		if !contains(out.Removed, name) {
+			out.Removed = append(out.Removed, name)
+		}
+	}
+	cached := forgetProcessCache(func(name string, _ time.Time) bool {
+		return logoutAll || wanted[name]
+	}, true)
+	for _, name := range names {
+		if !contains(out.Removed, name) && !contains(cached, name) {
+			logging.Warnf("Profile %s was not written by cu-sts, leaving it alone.", name)
+		}
+	}
+
+	if logoutAll {
+		dir, err := profile.CacheDir()
+		if err == nil {
+			err = idp.ForgetSessions(dir)
+		}
+		if err != nil {
+			logging.Warnf("Problem removing stored IdP session: %v", err)
+		} else {
+			out.Sessions = true
+			logging.Infof("Removed stored IdP session cookies.")
+		}
+
+		if dir := viper.GetString("browser_profile_dir"); dir != "" {
+			dir, err := homedir.Expand(dir)
+			if err != nil {
+				usageError(fmt.Sprintf("could not use browser_profile_dir: %v", err))
+			}
+			n, err := idp.ForgetBrowserProfiles(dir)
+			if err != nil {
+				logging.Warnf("Problem removing browser profiles: %v", err)
+			} else {
+				out.BrowserProfiles = true
+				logging.Infof("Removed %d browser profiles from %s.", n, dir)
+			}
+		}
+	}
+
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, out)
+	}
+}
+
+func pruneCommand(cmd *cobra.Command, args []string) {
+	files := logoutTargetFiles()
+	sections := sectionProfiles(files)
+	removed := removeSections(func(sect *ini.Section) bool {
+		return !sectionExpiration(sect).After(time.Now())
+	}, false)
+	for i, name := range removed {
+		removed[i] = sectionProfile(sections, name)
+	}
+	for _, name := range removeFromTargets(files, func(_ targetEntry, c jsonCreds) bool {
+		return !c.Expiration.After(time.Now())
+	}, false) {
+		if !contains(removed, name) {
+			removed = append(removed, name)
+		}
+	}
+	forgetProcessCache(func(name string, expiration time.Time) bool {
+		return !expiration.After(time.Now())
+	}, false)
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, logoutOutput{Removed: removed})
+	}
+}
+
+// removeSections deletes the sections creds wrote for which remove returns
+// true and saves the file, returning their names. With scrub the file's
+// backups, which still hold them, are deleted too.
+func removeSections(remove func(*ini.Section) bool, scrub bool) []string {
+	f, err := openCredsFile(logoutFile)
+	if err != nil {
+		fatalError(fmt.Sprintf("could not use credentials file: %v", err))
+	}
+	defer f.Close()
+
+	removed := []string{}
+	for _, sect := range f.Cfg.Sections() {
+		if sect.HasKey(metaExpiration) && remove(sect) {
+			removed = append(removed, sect.Name())
+		}
+	}
+	if len(removed) == 0 {
+		logging.Infof("No cu-sts profiles to remove from %s.", f.Path)
+	}
+
+	for _, name := range removed {
+		f.Cfg.DeleteSection(name)
+		logging.Infof("Removed profile %s from %s.", name, f.Path)
+	}
+	if len(removed) > 0 {
+		if err = f.Save(); err != nil {
+			f.Close()
+			fatalError(fmt.Sprintf("could not save credentials file: %v", err))
+		}
+	}
+	// the backups hold earlier credentials even if none are removed now
+	if scrub {
+		if err = f.RemoveBackups(); err != nil {
+			logging.Warnf("Problem removing the backups of %s: %v", f.Path, err)
+		}
+	}
+	return removed
+}
+
+// logoutTargetFiles plans the targets of the configured profiles, with the
+// built-in credentials target writing to the file logout was given.
+func logoutTargetFiles() []*targetFile {
+	outFile, _ = homedir.Expand(logoutFile)
+	files, err := configuredTargetFiles()
+	if err != nil {
+		logging.Warnf("Not removing credentials from targets: %v", err)
+		return nil
+	}
+	return files
+}
+
+// sectionProfiles maps the sections the configured profiles' targets write to
+// the credentials file removeSections handles, rendered from the targets'
+// name templates, to the profiles writing them.
+func sectionProfiles(files []*targetFile) map[string]string {
+	sections := make(map[string]string)
+	for _, f := range files {
+		if f.Path != filepath.Clean(outFile) {
+			continue
+		}
+		for _, e := range f.Entries {
+			sections[e.Name] = e.Profile.Name
+		}
+	}
+	return sections
+}
+
+// sectionProfile returns the profile that writes section to the credentials
+// file, or the section's name if no configured profile does.
+func sectionProfile(sections map[string]string, section string) string {
+	if name, ok := sections[section]; ok {
+		return name
+	}
+	return section
+}
+
+// removeFromTargets removes the credentials in files, other than the
+// credentials file removeSections handles, for which remove returns true,
+// returning the profiles' names. With scrub the files' backups are deleted
+// too.
+func removeFromTargets(files []*targetFile, remove func(e targetEntry, c jsonCreds) bool, scrub bool) []string {
+	var removed []string
+	for _, f := range files {
+		if f.Path == filepath.Clean(outFile) {
+			continue
+		}
+		names, err := removeTargetEntries(f, remove, scrub)
+		if err != nil {
+			logging.Warnf("Problem removing credentials from %s: %v", f.Path, err)
+		}
+		for _, name := range names {
+			logging.Infof("Removed profile %s from %s.", name, f.Path)
+			if !contains(removed, name) {
+				removed = append(removed, name)
+			}
+		}
+	}
+	return removed
+}
+
+// removeTargetEntries locks f's file and removes the entries creds wrote for
+// which remove returns true, deleting the file if nothing is left.
+func removeTargetEntries(f *targetFile, remove func(e targetEntry, c jsonCreds) bool, scrub bool) (names []string, err error) {
+	lf, err := lockFilePath(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer lf.Close()
+	// the backups hold earlier credentials even if none are removed now
+	if scrub {
+		defer func() {
+			if err == nil {
+				err = lf.RemoveBackups()
+			}
+		}()
+	}
+
+	data, err := lf.Read()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	format := targetFormats[f.Type]
+	var entries []targetEntry
+	for _, e := range f.Entries {
+		c, err := format.read(data, e)
+		if err != nil {
+			return nil, err
+		}
+		if !c.Expiration.IsZero() && remove(e, c) {
+			entries = append(entries, e)
+			names = append(names, e.Profile.Name)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if data, err = format.remove(data, entries); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return names, lf.Remove()
+	}
+	return names, lf.Replace(data)
+}
+
+// forgetProcessCache removes the process command's cached credentials for
+// which remove returns true, returning their names.
+func forgetProcessCache(remove func(name string, expiration time.Time) bool, scrub bool) []string {
+	removed, err := removeProcessCache(remove, scrub)
+	if err != nil {
+		logging.Warnf("Problem removing cached process credentials: %v", err)
+	}
+	for _, name := range removed {
+		logging.Infof("Removed cached process credentials for %s.", name)
+	}
+	return removed
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+
+	"cu-sts/logging"
+)
+
+// Values of the --output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// writeJSON writes v to w as a single line of JSON.
+func writeJSON(w io.Writer, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("unable to encode output: %v", err)
+	}
+}
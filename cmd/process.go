@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/cobra"
+)
+
+// processRefreshDefault is how long before they expire cached credentials
+// are replaced.
+const processRefreshDefault = 5 * time.Minute
+
+var processRefresh time.Duration
+
+// processCmd represents the process command
+var processCmd = &cobra.Command{
+	Use:   "process",
+	Short: "Prints credentials for an AWS credential_process.",
+	Long: `Prints a profile's credentials in the JSON format of the AWS CLI and SDKs'
+credential_process setting, so AWS_PROFILE works with cu-sts profiles. The
+credentials are cached in the cache directory until they expire within
+--refresh-within, since the AWS CLI runs the process for every command.`,
+	Run:    processCommand,
+	PreRun: validateSingleProfileArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(processCmd)
+
+	processCmd.Flags().DurationVar(&processRefresh, "refresh-within", processRefreshDefault, "log in again when the cached credentials expire within this duration")
+}
+
+func processCommand(cmd *cobra.Command, args []string) {
+	p := profiles[0]
+	// the AWS SDKs run process, so it runs no hooks
+	sdkCommand = true
+	creds := cachedCredentials(&p, processRefresh)
+	writeJSON(os.Stdout, newJSONCreds(&p, creds))
+}
+
+// cachedCredentials returns p's credentials from the process cache if they're
+// valid for more than window, otherwise it logs in and caches new ones.
+func cachedCredentials(p *profile.Profile, window time.Duration) *sts.Credentials {
+	// protected profiles are confirmed on every call, cached or not
+	confirmProtected(p)
+	if creds := lookupCredentials(p, window); creds != nil {
+		return creds
+	}
+	return cacheNewCredentials(p)
+}
+
+// lookupCredentials returns p's credentials from the process cache if they're
+// valid for more than window, or nil.
+func lookupCredentials(p *profile.Profile, window time.Duration) *sts.Credentials {
+	dir, err := profile.CacheDir()
+	if err != nil {
+		fatalError(err.Error())
+	}
+	cached, err := profile.LoadCachedCredentials(dir, p.Name, window)
+	if err != nil {
+		logging.Warnf("Ignoring cached credentials: %v", err)
+	}
+	if cached != nil {
+		logging.Debugf("Using cached credentials for %s, valid for %v.", p.Name, time.Until(cached.Expiration).Truncate(time.Second))
+		p.AssumedRoleARN = cached.RoleArn
+		logging.AddSecret(cached.SecretAccessKey)
+		logging.AddSecret(cached.SessionToken)
+		return cached.STS()
+	}
+	return nil
+}
+
+// cacheNewCredentials logs in for p's credentials and caches them.
+func cacheNewCredentials(p *profile.Profile) *sts.Credentials {
+	path, err := processCachePath()
+	if err != nil {
+		fatalError(err.Error())
+	}
+	ctxt, stop := loginContext()
+	creds, err := newClient(p).Credentials(ctxt, p)
+	stop()
+	if err != nil {
+		fatalErr(err, "failed to fetch credentials")
+	}
+	lookupAlias(p, creds)
+	logging.Infof("Received AWS STS credentials for %s (%s).", p.Name, p.Label())
+
+	err = writeTargetFile(&targetFile{
+		Path: path,
+		Type: targetJSON,
+		Entries: []targetEntry{
+			{Profile: p, Creds: creds, Name: p.Name},
+		},
+	})
+	if err != nil {
+		logging.Warnf("Could not cache credentials: %v", err)
+	}
+	return creds
+}
+
+// processCachePath returns the file process caches credentials in.
+func processCachePath() (string, error) {
+	dir, err := profile.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return profile.CredentialsCachePath(dir), nil
+}
+
+// removeProcessCache deletes the cached process credentials for which remove
+// returns true, returning their names. With scrub the cache's backups, which
+// still hold them, are deleted too.
+func removeProcessCache(remove func(name string, expiration time.Time) bool, scrub bool) (removed []string, err error) {
+	path, err := processCachePath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := lockFilePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// the backups hold earlier credentials even if none are removed now
+	if scrub {
+		defer func() {
+			if err == nil {
+				err = f.RemoveBackups()
+			}
+		}()
+	}
+
+	data, err := f.Read()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	objs, err := jsonFormat{}.load(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, raw := range objs {
+		var c jsonCreds
+		json.Unmarshal(raw, &c)
+		if remove(name, c.Expiration) {
+			delete(objs, name)
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if data, err = json.MarshalIndent(objs, "", "  "); err != nil {
+		return nil, err
+	}
+	return removed, f.Replace(append(data, '\n'))
+}
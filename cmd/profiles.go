@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"cu-sts/profile"
+
+	"github.com/spf13/cobra"
+)
+
+// profilesCmd represents the profiles command
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Lists the profiles in the config file.",
+	Long:  ``,
+	Run:   profilesCommand,
+	// Listing doesn't need the --profiles/--account validation of the root command.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(profilesCmd)
+}
+
+func profilesCommand(cmd *cobra.Command, args []string) {
+	var names []string
+	for name := range profile.Profiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tACCOUNT\tALIAS\tROLE\tDURATION")
+	for _, name := range names {
+		p, err := profile.NewFromConfig(name)
+		if err != nil {
+			fatalErr(err, "unable to load profile")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", p.Name, p.Account, p.Alias, p.Role, p.Duration)
+	}
+	w.Flush()
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var promptFormat string
+
+// promptSegment is the data --format is executed with.
+type promptSegment struct {
+	Profile   string
+	Account   string
+	Alias     string
+	Role      string
+	Remaining string
+	Expired   bool
+	Protected bool
+}
+
+// promptCmd represents the prompt command
+var promptCmd = &cobra.Command{
+	Use:   "prompt",
+	Short: "Prints the current profile and its remaining time for a shell prompt.",
+	Long: `Prints a compact "admin (37m left)" segment for PS1 or starship from the
+CUSTS_PROFILE and CUSTS_EXPIRATION variables set by exec and the shell-init
+function. It prints nothing outside of a cu-sts profile. Log messages go to
+STDERR, never into the segment, but use --quiet so the config file message
+isn't printed with every prompt.`,
+	Run: promptCommand,
+	// The prompt only reads the environment, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(promptCmd)
+
+	promptCmd.Flags().StringVar(&promptFormat, "format", "{{.Profile}} ({{if .Expired}}expired{{else}}{{.Remaining}} left{{end}})",
+		"Go template with .Profile, .Account, .Alias, .Role, .Remaining, .Expired and .Protected")
+}
+
+func promptCommand(cmd *cobra.Command, args []string) {
+	name := os.Getenv("CUSTS_PROFILE")
+	if name == "" {
+		return
+	}
+	t, err := template.New("prompt").Parse(promptFormat)
+	if err != nil {
+		usageError(fmt.Sprintf("invalid --format: %v", err))
+	}
+
+	segment := promptSegment{
+		Profile:   name,
+		Account:   os.Getenv("CUSTS_ACCOUNT"),
+		Alias:     os.Getenv("CUSTS_ACCOUNT_ALIAS"),
+		Role:      os.Getenv("CUSTS_ROLE"),
+		Remaining: "?",
+		Protected: os.Getenv("CUSTS_PROTECTED") == "1",
+	}
+	if expiration, err := time.Parse(time.RFC3339, os.Getenv("CUSTS_EXPIRATION")); err == nil {
+		remaining := time.Until(expiration)
+		segment.Expired = remaining <= 0
+		segment.Remaining = compactDuration(remaining)
+	}
+
+	if err = t.Execute(os.Stdout, segment); err != nil {
+		usageError(fmt.Sprintf("invalid --format: %v", err))
+	}
+	fmt.Println()
+}
+
+// compactDuration formats d like 1h05m, 37m or 45s.
+func compactDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d > 0:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return "0s"
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cu-sts/audit"
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// errNotConfirmed is returned when a protected profile isn't confirmed.
+var errNotConfirmed = errors.New("protected profile not confirmed")
+
+var bannerColors = map[string]color.Attribute{
+	"red":     color.BgRed,
+	"yellow":  color.BgYellow,
+	"green":   color.BgGreen,
+	"blue":    color.BgBlue,
+	"magenta": color.BgMagenta,
+	"cyan":    color.BgCyan,
+	"white":   color.BgWhite,
+}
+
+// How a protected profile was confirmed, recorded in the audit log.
+const (
+	confirmedByPrompt = "prompt"
+	confirmedByFlag   = "flag"
+)
+
+// confirmed are the protected profiles already confirmed by this run, and how.
+var confirmed = make(map[string]string)
+
+// confirmProtected shows p's banner and, if p is protected, exits unless the
+// user confirms it or it's named by --confirm-protected. Each profile is
+// confirmed once per run. The commands the AWS SDKs and kubectl run only show
+// protected profiles' banners, since their STDERR goes to whatever ran the
+// SDK, and only prompt when STDERR is a terminal too, or the prompt wouldn't be
+// seen.
+func confirmProtected(p *profile.Profile) {
+	if confirmed[p.Name] != "" || (!p.Protected && p.Color == "") {
+		return
+	}
+	if !sdkCommand || p.Protected {
+		showBanner(p)
+	}
+	if !p.Protected {
+		return
+	}
+
+	if contains(viper.GetStringSlice("confirm_protected"), p.Name) {
+		logging.Warnf("Protected profile %s confirmed by --confirm-protected.", p.Name)
+		confirmed[p.Name] = confirmedByFlag
+		return
+	}
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	if sdkCommand {
+		interactive = interactive && term.IsTerminal(int(os.Stderr.Fd()))
+	}
+	if !interactive {
+		fatalErr(fmt.Errorf("%w: no terminal to confirm it, use --confirm-protected=%s for this command", errNotConfirmed, p.Name),
+			fmt.Sprintf("cannot use protected profile %s", p.Name))
+	}
+
+	want := confirmText(p)
+	prompt := fmt.Sprintf("Continue with protected profile %s? [y/N]: ", p.Name)
+	if want != "" {
+		prompt = fmt.Sprintf("Type %q to continue with protected profile %s: ", want, p.Name)
+	}
+	color.New(color.FgYellow).Fprint(os.Stderr, prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+
+	ok := answer == want
+	if want == "" {
+		ok = strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+	}
+	if !ok {
+		fatalErr(errNotConfirmed, fmt.Sprintf("not continuing with %s", p.Name))
+	}
+	confirmed[p.Name] = confirmedByPrompt
+}
+
+// logAudit records issued credentials in the audit log, along with how a
+// protected profile was confirmed.
+func logAudit(r audit.Record) error {
+	r.Confirmed = confirmed[r.Profile]
+	return audit.Log(r)
+}
+
+// confirmText returns what must be typed to confirm p, or "" for y/N.
+func confirmText(p *profile.Profile) string {
+	switch p.Confirm {
+	case "alias":
+		if p.Alias != "" {
+			return p.Alias
+		}
+		return p.Account
+	case "account":
+		return p.Account
+	case "profile":
+		return p.Name
+	}
+	return p.Confirm
+}
+
+// showBanner prints a line in p's color identifying the account and role.
+func showBanner(p *profile.Profile) {
+	label := "PROFILE"
+	if p.Protected {
+		label = "PROTECTED"
+	}
+	text := fmt.Sprintf(" %s  %s  %s  %s ", label, p.Name, p.Label(), p.Role)
+	if viper.GetString("log_format") == logging.JSONFormat {
+		logging.Warnf("%s", strings.TrimSpace(text))
+		return
+	}
+
+	bg, ok := bannerColors[p.Color]
+	if !ok {
+		bg = color.BgRed
+	}
+	fg := color.FgWhite
+	if bg == color.BgYellow || bg == color.BgWhite || bg == color.BgCyan {
+		fg = color.FgBlack
+	}
+	color.New(bg, fg, color.Bold).Fprintln(os.Stderr, text)
+}
@@ -1,29 +1,58 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
+	"cu-sts/audit"
+	"cu-sts/custs"
+	"cu-sts/idp"
+	"cu-sts/logging"
 	"cu-sts/profile"
 
-	"github.com/fatih/color"
+	"github.com/aws/aws-sdk-go/service/sts"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	cfgFile           string
-	account           string
-	role              string
-	username          string
-	duration          int
-	idProvider        string
-	profilesFlag      []string
-	singleProfileFlag string
-	profiles          []profile.Profile
-	duoMethod         string
-	debug             bool
+	cfgFile                string
+	account                string
+	role                   string
+	username               string
+	duration               int
+	idProvider             string
+	profilesFlag           []string
+	singleProfileFlag      string
+	profiles               []profile.Profile
+	duoMethod              string
+	debug                  bool
+	quiet                  bool
+	logFormat              string
+	outputFormat           string
+	resolveAliases         bool
+	duoRetries             int
+	duoFallback            string
+	browserMode            string
+	browserProfileDir      string
+	duoRemember            bool
+	sessionCookies         bool
+	debugDir               string
+	flowFile               string
+	passwordCommand        string
+	passwordCommandShell   bool
+	passwordCommandTimeout int
+	overallTimeout         int
+	loginTimeout           int
+	duoTimeout             int
+	duoFrameTimeout        int
+	confirmProtectedFlag   []string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -39,8 +68,7 @@ var rootCmd = &cobra.Command{
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		usageError(err.Error())
 	}
 }
 
@@ -50,11 +78,31 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cu-sts.toml)")
 	rootCmd.PersistentFlags().StringVar(&username, "username", "", "username for IdP login")
 	rootCmd.PersistentFlags().StringVar(&duoMethod, "duo-method", "push", "DUO method to use (push or call)")
+	rootCmd.PersistentFlags().IntVar(&duoRetries, "duo-retries", 0, "number of times to re-send a DUO request that timed out")
+	rootCmd.PersistentFlags().StringVar(&duoFallback, "duo-fallback", "", "DUO method to use when re-sending a request (push or call)")
+	rootCmd.PersistentFlags().StringVar(&browserMode, "browser", "headless", "how to run the login flow: headless Chrome, visible Chrome to finish the login by hand, or http without Chrome")
+	rootCmd.PersistentFlags().StringVar(&browserProfileDir, "browser-profile-dir", "", "persistent Chrome profile directory so the IdP session and DUO remembered device are reused, with a subdirectory per identity when logging in as several")
+	rootCmd.PersistentFlags().BoolVar(&duoRemember, "duo-remember", false, "tick DUO's \"Remember me\" checkbox (needs --browser-profile-dir)")
+	rootCmd.PersistentFlags().BoolVar(&sessionCookies, "session-cookies", false, "keep the IdP session cookies encrypted in the cache directory and try them before starting Chrome")
+	rootCmd.PersistentFlags().StringVar(&debugDir, "debug-dir", "", "directory for screenshots and page HTML of failed logins (default is debug/ in the cache directory)")
+	rootCmd.PersistentFlags().StringVar(&flowFile, "flow-file", "", "TOML or YAML login flow to use instead of the built-in Cornell flow")
+	rootCmd.PersistentFlags().StringVar(&passwordCommand, "password-command", "", "command printing the NetID password, run instead of prompting")
+	rootCmd.PersistentFlags().BoolVar(&passwordCommandShell, "password-command-shell", false, "run --password-command with the shell")
+	rootCmd.PersistentFlags().IntVar(&passwordCommandTimeout, "password-command-timeout", 30, "timeout for --password-command, in seconds")
+	rootCmd.PersistentFlags().IntVar(&overallTimeout, "timeout", 120, "overall login timeout, in seconds")
+	rootCmd.PersistentFlags().IntVar(&loginTimeout, "login-timeout", 15, "timeout for the NetID/password page, in seconds")
+	rootCmd.PersistentFlags().IntVar(&duoTimeout, "duo-timeout", 30, "timeout for loading the DUO frame and selecting the method, in seconds")
+	rootCmd.PersistentFlags().IntVar(&duoFrameTimeout, "duo-frame-timeout", 20, "time to poll for the DUO frame's buttons, in seconds")
 	rootCmd.PersistentFlags().StringVar(&account, "account", "", "account number of role")
 	rootCmd.PersistentFlags().StringVar(&role, "role", "", "name of the role")
 	rootCmd.PersistentFlags().IntVar(&duration, "duration", 3600, "requested duration of credentials, in seconds")
 	rootCmd.PersistentFlags().StringVar(&idProvider, "id-provider", "cornell_idp", "name of the Identity Provider in IAM")
-	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "prints Chrome debug info to STDERR")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "prints debug and Chrome debug info to STDERR")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only print warnings and errors to STDERR")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "format of diagnostics on STDERR (text or json)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "format of command output (text or json)")
+	rootCmd.PersistentFlags().BoolVar(&resolveAliases, "resolve-aliases", false, "look up and cache the account alias after fetching credentials")
+	rootCmd.PersistentFlags().StringSliceVar(&confirmProtectedFlag, "confirm-protected", nil, "protected profiles to use without the confirmation prompt, for audited automation")
 
 	rootCmd.PersistentFlags().StringSliceVar(&profilesFlag, "profiles", nil, "profiles to get STS credentials for")
 	rootCmd.PersistentFlags().StringVar(&singleProfileFlag, "profile", "", "single profile to get STS credentials for")
@@ -63,6 +111,27 @@ func init() {
 	viper.BindPFlag("duo_method", rootCmd.PersistentFlags().Lookup("duo-method"))
 	viper.BindPFlag("duration", rootCmd.PersistentFlags().Lookup("duration"))
 	viper.BindPFlag("id_provider", rootCmd.PersistentFlags().Lookup("id-provider"))
+	viper.BindPFlag("resolve_aliases", rootCmd.PersistentFlags().Lookup("resolve-aliases"))
+	viper.BindPFlag("log_format", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("duo_retries", rootCmd.PersistentFlags().Lookup("duo-retries"))
+	viper.BindPFlag("duo_fallback", rootCmd.PersistentFlags().Lookup("duo-fallback"))
+	viper.BindPFlag("browser", rootCmd.PersistentFlags().Lookup("browser"))
+	viper.BindPFlag("browser_profile_dir", rootCmd.PersistentFlags().Lookup("browser-profile-dir"))
+	viper.BindPFlag("duo_remember", rootCmd.PersistentFlags().Lookup("duo-remember"))
+	viper.BindPFlag("session_cookies", rootCmd.PersistentFlags().Lookup("session-cookies"))
+	viper.BindPFlag("debug_dir", rootCmd.PersistentFlags().Lookup("debug-dir"))
+	viper.BindPFlag("flow_file", rootCmd.PersistentFlags().Lookup("flow-file"))
+	viper.BindPFlag("password_command", rootCmd.PersistentFlags().Lookup("password-command"))
+	viper.BindPFlag("password_command_shell", rootCmd.PersistentFlags().Lookup("password-command-shell"))
+	viper.BindPFlag("password_command_timeout", rootCmd.PersistentFlags().Lookup("password-command-timeout"))
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	viper.BindPFlag("login_timeout", rootCmd.PersistentFlags().Lookup("login-timeout"))
+	viper.BindPFlag("duo_timeout", rootCmd.PersistentFlags().Lookup("duo-timeout"))
+	viper.BindPFlag("duo_frame_timeout", rootCmd.PersistentFlags().Lookup("duo-frame-timeout"))
+	viper.BindPFlag("confirm_protected", rootCmd.PersistentFlags().Lookup("confirm-protected"))
+
+	rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	rootCmd.RegisterFlagCompletionFunc("profiles", completeProfiles)
 }
 
 func validateRootArgs(cmd *cobra.Command, args []string) {
@@ -71,27 +140,86 @@ func validateRootArgs(cmd *cobra.Command, args []string) {
 	// kind of dumb and verbose because we can't mark flags as *required* or set
 	// reasonable defaults. So we're stuck just checking all invalid combinations.
 
+	// shell completion requests don't log in
+	if cmd.Name() == cobra.ShellCompRequestCmd {
+		return
+	}
+
 	// Hack to allow a single profile to be passed by --profile
 	if singleProfileFlag != "" {
 		profilesFlag = append(profilesFlag, singleProfileFlag)
 	}
 
 	if profilesFlag == nil && account == "" {
-		fatalError("must use --profiles or --username/--role.")
+		usageError("must use --profiles or --username/--role.")
 	}
 
 	if profilesFlag != nil && (account != "" || role != "") {
-		fatalError("cannot use --profiles and --username/--role together.")
+		usageError("cannot use --profiles and --username/--role together.")
 	}
 
 	if account != "" && role == "" {
-		fatalError("--account and --role must be used together.")
+		usageError("--account and --role must be used together.")
+	}
+
+	// profiles can set their own username, which profile.Validate checks
+	if profilesFlag == nil && viper.GetString("username") == "" {
+		usageError("username must be set via --username flag or config file.")
+	}
+
+	if m := viper.GetString("duo_method"); m != "push" && m != "call" {
+		usageError(fmt.Sprintf("unknown DUO method %s, must be push or call.", m))
+	}
+	if m := viper.GetString("duo_fallback"); m != "" && m != "push" && m != "call" {
+		usageError(fmt.Sprintf("unknown DUO fallback method %s, must be push or call.", m))
+	}
+
+	if b := viper.GetString("browser"); b != idp.BrowserHeadless && b != idp.BrowserVisible && b != idp.BrowserHTTP {
+		usageError(fmt.Sprintf("unknown browser mode %s, must be headless, visible or http.", b))
+	}
+
+	for _, k := range []string{"timeout", "login_timeout", "duo_timeout", "duo_frame_timeout", "password_command_timeout"} {
+		if viper.GetInt(k) <= 0 {
+			usageError(fmt.Sprintf("%s must be a positive number of seconds.", k))
+		}
 	}
 
-	if viper.GetString("username") == "" {
-		fatalError("username must be set via --username flag or config file.")
-		os.Exit(1)
+	if _, err := profile.GlobalHooks(); err != nil {
+		usageError(err.Error())
 	}
+
+	path, keep, err := auditFile()
+	if err != nil {
+		usageError(err.Error())
+	}
+	size := int64(audit.DefaultMaxSize)
+	if mb := viper.GetInt("audit_log_max_size"); mb > 0 {
+		size = int64(mb) << 20
+	}
+	audit.SetFile(path, size, keep)
+	commandName = strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	audit.SetCommand(commandName)
+}
+
+// auditFile returns the audit log file, audit_log or audit.log in the cache
+// directory, and how many rotated backups of it are kept.
+func auditFile() (string, int, error) {
+	keep := audit.DefaultBackups
+	if viper.IsSet("audit_log_backups") {
+		keep = viper.GetInt("audit_log_backups")
+	}
+	if path := viper.GetString("audit_log"); path != "" {
+		expanded, err := homedir.Expand(path)
+		if err != nil {
+			return "", 0, fmt.Errorf("unable to expand audit_log %s: %v", path, err)
+		}
+		return expanded, keep, nil
+	}
+	dir, err := profile.CacheDir()
+	if err != nil {
+		return "", 0, err
+	}
+	return filepath.Join(dir, "audit.log"), keep, nil
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -115,14 +243,114 @@ func initConfig() {
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Loaded config file:", viper.ConfigFileUsed())
+	configErr := viper.ReadInConfig()
+
+	// Logging is set up after reading the config so log_format can come from it.
+	initLogging()
+
+	if configErr == nil {
+		logging.Infof("Loaded config file: %s", viper.ConfigFileUsed())
+	} else if _, ok := configErr.(viper.ConfigFileNotFoundError); ok {
+		logging.Debugf("No config file found: %v", configErr)
 	} else {
-		fmt.Println("Error reading config file:", err)
+		logging.Warnf("Error reading config file: %v", configErr)
+	}
+}
+
+// initLogging applies the --debug, --quiet and --log-format settings.
+func initLogging() {
+	if outputFormat != outputText && outputFormat != outputJSON {
+		usageError(fmt.Sprintf("unknown output format %s, must be %s or %s.", outputFormat, outputText, outputJSON))
+	}
+
+	switch {
+	case debug:
+		logging.SetLevel(logging.DebugLevel)
+	case quiet:
+		logging.SetLevel(logging.WarnLevel)
+	}
+
+	if err := logging.SetFormat(viper.GetString("log_format")); err != nil {
+		usageError(err.Error())
+	}
+}
+
+// applyIdentityFlags sets p's Username and DuoMethod from the top-level
+// config keys, or from --username and --duo-method if they were given, which
+// override the profile's own keys.
+func applyIdentityFlags(p *profile.Profile) {
+	flags := rootCmd.PersistentFlags()
+	if p.Username == "" || flags.Changed("username") {
+		p.Username = viper.GetString("username")
+	}
+	if p.DuoMethod == "" || flags.Changed("duo-method") {
+		p.DuoMethod = viper.GetString("duo_method")
+	}
+}
+
+// idpSettings builds the IdP login settings for p's identity from flags and
+// the config file, the same way the custs package does for its providers.
+func idpSettings(p *profile.Profile) idp.Settings {
+	s, err := custs.Settings(viper.GetViper(), p)
+	if err != nil {
+		fatalErr(err, "invalid login settings")
 	}
+	s.Debug = debug
+
+	// when a run logs in as several identities, each gets its own Chrome
+	// profile, so concurrent logins don't share a session or fight over
+	// Chrome's profile lock
+	if s.BrowserProfileDir != "" && identities() > 1 {
+		s.BrowserProfileDir = filepath.Join(s.BrowserProfileDir, p.Username+"-"+p.DuoMethod)
+	}
+	return s
+}
+
+// identities returns how many identities the profiles of this run log in as.
+func identities() int {
+	seen := make(map[string]bool)
+	for i := range profiles {
+		seen[profiles[i].Identity()] = true
+	}
+	return len(seen)
+}
+
+// newClient returns the login client for p's identity.
+func newClient(p *profile.Profile) *custs.Client {
+	return custs.NewClient(
+		custs.WithSettings(idpSettings(p)),
+		custs.WithPassword(custs.Password(viper.GetViper(), p)),
+		custs.WithAudit(logAudit),
+	)
 }
 
-func fatalError(message string) {
-	color.Red("ERROR: %s", message)
-	os.Exit(1)
+// loginContext returns a context cancelled by SIGINT, so an interrupted login
+// shuts Chrome down instead of leaving it orphaned. stop must be called once
+// the login is done.
+func loginContext() (ctxt context.Context, stop func()) {
+	ctxt, cancel := context.WithCancel(context.Background())
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT)
+	go func() {
+		select {
+		case <-signalChan:
+			cancel()
+		case <-ctxt.Done():
+		}
+	}()
+	return ctxt, func() {
+		signal.Stop(signalChan)
+		cancel()
+	}
+}
+
+// lookupAlias resolves and caches the account alias for p when enabled, warning
+// on failure since the role may not be allowed iam:ListAccountAliases.
+func lookupAlias(p *profile.Profile, creds *sts.Credentials) {
+	if !viper.GetBool("resolve_aliases") {
+		return
+	}
+	if err := p.LookupAlias(creds); err != nil {
+		logging.Warnf("Problem resolving account alias: %v", err)
+	}
 }
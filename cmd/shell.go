@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Shells shell-init and env support.
+var shells = []string{"bash", "zsh", "fish"}
+
+var (
+	envShell          string
+	envUnset          bool
+	shellInitFunction string
+)
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Prints shell commands that switch to a profile's credentials.",
+	Long: `Prints the commands that export a profile's credentials and CUSTS_*
+variables in the current shell, for eval. The shell function from shell-init
+runs it. Credentials are shared with the process command's cache, so switching
+back to a profile doesn't log in again while they're valid.`,
+	Run: envCommand,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// unsetting doesn't log in
+		if !envUnset {
+			validateRootArgs(cmd, args)
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if !envUnset {
+			validateSingleProfileArgs(cmd, args)
+		}
+	},
+}
+
+// shellInitCmd represents the shell-init command
+var shellInitCmd = &cobra.Command{
+	Use:   "shell-init bash|zsh|fish",
+	Short: "Prints a shell function to switch profiles and tab completion.",
+	Long: `Prints a cu-sts-assume shell function that switches the current shell to a
+profile's credentials, and tab completion for cu-sts commands, flags and
+profile names. Load it from your shell's startup file:
+
+  eval "$(cu-sts shell-init bash)"
+  eval "$(cu-sts shell-init zsh)"
+  cu-sts shell-init fish | source`,
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: shells,
+	Run:       shellInitCommand,
+	// Printing the scripts doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(shellInitCmd)
+
+	envCmd.Flags().StringVar(&envShell, "shell", "bash", "shell to print commands for (bash, zsh or fish)")
+	envCmd.Flags().BoolVarP(&envUnset, "unset", "u", false, "print commands that clear the credentials instead")
+	envCmd.Flags().DurationVar(&processRefresh, "refresh-within", processRefreshDefault, "log in again when the cached credentials expire within this duration")
+	envCmd.RegisterFlagCompletionFunc("shell", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return shells, cobra.ShellCompDirectiveNoFileComp
+	})
+	shellInitCmd.Flags().StringVar(&shellInitFunction, "function", "cu-sts-assume", "name of the shell function")
+}
+
+func envCommand(cmd *cobra.Command, args []string) {
+	if !contains(shells, envShell) {
+		usageError(fmt.Sprintf("unknown shell %s, must be bash, zsh or fish.", envShell))
+	}
+
+	if envUnset {
+		for _, name := range staleEnv {
+			fmt.Println(shellUnset(envShell, name))
+		}
+		return
+	}
+
+	p := profiles[0]
+	confirmProtected(&p)
+	// like with creds and exec, the hooks only run when env logs in
+	creds := lookupCredentials(&p, processRefresh)
+	if creds == nil {
+		runHooks(hookPreLogin, &p, nil)
+		creds = cacheNewCredentials(&p)
+		runHooks(hookPostCredentials, &p, creds)
+	}
+	logging.Infof("Switched to %s (%s), valid until %s.", p.Name, p.Label(), creds.Expiration.Local().Format("15:04"))
+	for _, name := range staleEnv {
+		fmt.Println(shellUnset(envShell, name))
+	}
+	for _, kv := range credentialsEnv(&p, creds) {
+		fmt.Println(shellExport(envShell, kv[0], kv[1]))
+	}
+}
+
+// shellExport returns the command that exports name=value in shell.
+func shellExport(shell, name, value string) string {
+	if shell == "fish" {
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		return fmt.Sprintf("set -gx %s '%s';", name, value)
+	}
+	return fmt.Sprintf("export %s='%s';", name, strings.Replace(value, `'`, `'\''`, -1))
+}
+
+// shellUnset returns the command that removes name in shell.
+func shellUnset(shell, name string) string {
+	if shell == "fish" {
+		return fmt.Sprintf("set -e %s;", name)
+	}
+	return fmt.Sprintf("unset %s;", name)
+}
+
+var shellFunctions = map[string]string{
+	"bash": `# cu-sts shell integration, load with: eval "$(cu-sts shell-init {{.Shell}})"
+{{.Function}}() {
+  if [ $# -gt 0 ] && [ "${1#-}" = "$1" ]; then
+    set -- --profile "$@"
+  fi
+  local script
+  script="$(command {{.Command}} env --shell {{.Shell}} "$@")" || return
+  eval "$script"
+}
+`,
+	"fish": `# cu-sts shell integration, load with: cu-sts shell-init fish | source
+function {{.Function}} --description 'Switch this shell to a cu-sts profile'
+    if set -q argv[1]; and not string match -q -- '-*' $argv[1]
+        set argv --profile $argv
+    end
+    set -l script (command {{.Command}} env --shell fish $argv); or return
+    printf '%s\n' $script | source
+end
+`,
+}
+
+var shellFunctionCompletions = map[string]string{
+	"bash": `_{{.Ident}}() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  COMPREPLY=($(compgen -W "$(command {{.Command}} __completeNoDesc env --profile "" 2>/dev/null | grep -v '^:')" -- "$cur"))
+}
+complete -F _{{.Ident}} {{.Function}}
+`,
+	"zsh": `compdef _cu-sts cu-sts
+_{{.Ident}}() {
+  local -a profiles
+  profiles=(${(f)"$(command {{.Command}} __completeNoDesc env --profile "" 2>/dev/null | grep -v '^:')"})
+  compadd -a profiles
+}
+compdef _{{.Ident}} {{.Function}}
+`,
+	"fish": `complete -c {{.Function}} -f -a "(command {{.Command}} __completeNoDesc env --profile '' 2>/dev/null | string match -v ':*')"
+`,
+}
+
+func shellInitCommand(cmd *cobra.Command, args []string) {
+	shell := args[0]
+	command := rootCmd.Name()
+	if cfgFile != "" {
+		abs, _ := filepath.Abs(cfgFile)
+		command = fmt.Sprintf("%s --config '%s'", command, strings.Replace(abs, `'`, `'\''`, -1))
+	}
+	data := struct {
+		Shell, Function, Ident, Command string
+	}{shell, shellInitFunction, strings.Replace(shellInitFunction, "-", "_", -1), command}
+
+	function := shellFunctions[shell]
+	if shell == "zsh" {
+		function = shellFunctions["bash"]
+	}
+	if err := template.Must(template.New(shell).Parse(function)).Execute(os.Stdout, data); err != nil {
+		fatalError(err.Error())
+	}
+
+	var err error
+	switch shell {
+	case "bash":
+		err = rootCmd.GenBashCompletion(os.Stdout)
+	case "zsh":
+		err = rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = rootCmd.GenFishCompletion(os.Stdout, true)
+	}
+	if err == nil {
+		err = template.Must(template.New(shell).Parse(shellFunctionCompletions[shell])).Execute(os.Stdout, data)
+	}
+	if err != nil {
+		fatalError(err.Error())
+	}
+}
+
+// completeProfiles completes --profile and --profiles with the config file's
+// profile names.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completionConfig()
+	var names []string
+	for name := range profile.Profiles() {
+		names = append(names, name)
+	}
+	return completeList(names, toComplete)
+}
+
+// completeTargets completes creds --targets with the config file's targets.
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completionConfig()
+	names := []string{defaultTarget}
+	for name := range viper.GetStringMap("target") {
+		if name != defaultTarget {
+			names = append(names, name)
+		}
+	}
+	return completeList(names, toComplete)
+}
+
+// completionConfig reads the --config file, which shell completion requests
+// only parse after the config has been loaded.
+func completionConfig() {
+	if cfgFile != "" && viper.ConfigFileUsed() != cfgFile {
+		viper.SetConfigFile(cfgFile)
+		viper.ReadInConfig()
+	}
+}
+
+// completeList completes the last item of a comma-separated list.
+func completeList(names []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	sort.Strings(names)
+	prefix := toComplete[:strings.LastIndex(toComplete, ",")+1]
+	var completions []string
+	for _, name := range names {
+		completions = append(completions, prefix+name)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestShellExport(t *testing.T) {
+	tests := []struct {
+		shell, value string
+		want         string
+	}{
+		{"bash", "AKIA123", `export AWS_ACCESS_KEY_ID='AKIA123';`},
+		{"bash", "it's", `export AWS_ACCESS_KEY_ID='it'\''s';`},
+		{"bash", `a\b $HOME`, `export AWS_ACCESS_KEY_ID='a\b $HOME';`},
+		{"zsh", "it's", `export AWS_ACCESS_KEY_ID='it'\''s';`},
+		{"fish", "AKIA123", `set -gx AWS_ACCESS_KEY_ID 'AKIA123';`},
+		{"fish", "it's", `set -gx AWS_ACCESS_KEY_ID 'it\'s';`},
+		{"fish", `a\b $HOME`, `set -gx AWS_ACCESS_KEY_ID 'a\\b $HOME';`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell+" "+tt.value, func(t *testing.T) {
+			if got := shellExport(tt.shell, "AWS_ACCESS_KEY_ID", tt.value); got != tt.want {
+				t.Errorf("shellExport() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"gopkg.in/ini.v1"
+)
+
+// Keys creds writes next to the credentials so status can describe them. The
+// AWS CLI and SDKs ignore unknown keys.
+const (
+	metaAccount    = "x_custs_account"
+	metaRole       = "x_custs_role"
+	metaRoleARN    = "x_custs_role_arn"
+	metaUsername   = "x_custs_username"
+	metaIssued     = "x_custs_issued"
+	metaExpiration = "x_custs_expiration"
+)
+
+var (
+	statusFile   string
+	statusVerify bool
+)
+
+// statusOutput describes a profile's credentials for --output json.
+type statusOutput struct {
+	Profile    string    `json:"profile"`
+	Source     string    `json:"source"`
+	Account    string    `json:"account"`
+	Role       string    `json:"role"`
+	RoleARN    string    `json:"role_arn,omitempty"`
+	Expiration time.Time `json:"expiration"`
+	Remaining  string    `json:"remaining"`
+	Expired    bool      `json:"expired"`
+	// With --verify, the ARN sts:GetCallerIdentity returned or its error.
+	VerifiedARN string `json:"verified_arn,omitempty"`
+	VerifyError string `json:"verify_error,omitempty"`
+
+	accessKeyID, secretAccessKey, sessionToken string
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Aliases: []string{"whoami"},
+	Short:   "Shows the identity and expiration of cu-sts credentials.",
+	Long: `Shows the account, role and expiration of every profile creds wrote to the
+credentials file or the profiles' other targets, and of the credentials
+process, env and eks token cached. Inside an exec sub-shell it describes the
+current CUSTS_PROFILE instead.`,
+	Run: statusCommand,
+	// Status doesn't log in, so skip the root validation.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVar(&statusFile, "out-file", "~/.aws/credentials", "credentials file creds writes to")
+	statusCmd.Flags().BoolVar(&statusVerify, "verify", false, "check the credentials with sts:GetCallerIdentity")
+}
+
+// setMetadata records p's identity and the credentials' expiration in sect.
+func setMetadata(sect *ini.Section, p *profile.Profile, creds *sts.Credentials) {
+	sect.Key(metaAccount).SetValue(p.Account)
+	sect.Key(metaRole).SetValue(p.Role)
+	if p.AssumedRoleARN != "" {
+		sect.Key(metaRoleARN).SetValue(p.AssumedRoleARN)
+	}
+	sect.Key(metaUsername).SetValue(p.Username)
+	sect.Key(metaIssued).SetValue(time.Now().UTC().Format(time.RFC3339))
+	sect.Key(metaExpiration).SetValue(creds.Expiration.UTC().Format(time.RFC3339))
+}
+
+// sectionExpiration returns the expiration creds recorded in sect, or the zero
+// time if there is none.
+func sectionExpiration(sect *ini.Section) time.Time {
+	expiration, _ := time.Parse(time.RFC3339, sect.Key(metaExpiration).String())
+	return expiration
+}
+
+func statusCommand(cmd *cobra.Command, args []string) {
+	var statuses []statusOutput
+	if name := os.Getenv("CUSTS_PROFILE"); name != "" {
+		statuses = append(statuses, envStatus(name))
+	} else {
+		statuses = fileStatuses()
+		statuses = append(statuses, targetStatuses()...)
+		statuses = append(statuses, cacheStatuses()...)
+	}
+
+	if statusVerify {
+		for i := range statuses {
+			verifyStatus(&statuses[i])
+		}
+	}
+
+	if outputFormat == outputJSON {
+		writeJSON(os.Stdout, statuses)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "PROFILE\tACCOUNT\tROLE\tARN\tEXPIRATION\tREMAINING\tSOURCE"
+	if statusVerify {
+		header += "\tVERIFIED"
+	}
+	fmt.Fprintln(w, header)
+	for _, s := range statuses {
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", s.Profile, s.Account, s.Role, s.RoleARN,
+			s.Expiration.Local().Format(time.RFC3339), s.Remaining, s.Source)
+		if statusVerify {
+			verified := "yes"
+			if s.VerifyError != "" {
+				verified = "no: " + s.VerifyError
+			}
+			line += "\t" + verified
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+// envStatus describes the credentials exec put in the environment.
+func envStatus(name string) statusOutput {
+	expiration, _ := time.Parse(time.RFC3339, os.Getenv("CUSTS_EXPIRATION"))
+	s := statusOutput{
+		Profile:         name,
+		Source:          "exec",
+		Account:         os.Getenv("CUSTS_ACCOUNT"),
+		Role:            os.Getenv("CUSTS_ROLE"),
+		RoleARN:         os.Getenv("CUSTS_ROLE_ARN"),
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	s.setExpiration(expiration)
+	return s
+}
+
+// fileStatuses describes the profiles in the credentials file that creds
+// wrote.
+func fileStatuses() []statusOutput {
+	path, _ := homedir.Expand(statusFile)
+	data, err := readIfExists(path)
+	if err != nil {
+		usageError(fmt.Sprintf("could not read credentials file: %v", err))
+	}
+	cfg, err := ini.Load(data)
+	if err != nil {
+		usageError(fmt.Sprintf("could not read credentials file %s: %v", path, err))
+	}
+
+	var statuses []statusOutput
+	for _, sect := range cfg.Sections() {
+		if !sect.HasKey(metaExpiration) {
+			continue
+		}
+		s := statusOutput{
+			Profile:         sect.Name(),
+			Source:          path,
+			Account:         sect.Key(metaAccount).String(),
+			Role:            sect.Key(metaRole).String(),
+			RoleARN:         sect.Key(metaRoleARN).String(),
+			accessKeyID:     sect.Key("aws_access_key_id").String(),
+			secretAccessKey: sect.Key("aws_secret_access_key").String(),
+			sessionToken:    sect.Key("aws_session_token").String(),
+		}
+		s.setExpiration(sectionExpiration(sect))
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// targetStatuses describes the credentials creds wrote to the configured
+// profiles' targets, other than the credentials file fileStatuses read.
+func targetStatuses() []statusOutput {
+	// the built-in credentials target is the file status was given
+	outFile, _ = homedir.Expand(statusFile)
+	files, err := configuredTargetFiles()
+	if err != nil {
+		usageError(fmt.Sprintf("could not read targets: %v", err))
+	}
+
+	var statuses []statusOutput
+	for _, f := range files {
+		if f.Path == filepath.Clean(outFile) {
+			continue
+		}
+		data, err := readIfExists(f.Path)
+		if err != nil {
+			logging.Warnf("Could not read target %s: %v", f.Path, err)
+			continue
+		}
+		for _, e := range f.Entries {
+			c, err := e.Target.format.read(data, e)
+			if err != nil {
+				logging.Warnf("Could not read target %s: %v", f.Path, err)
+				break
+			}
+			if !c.Expiration.IsZero() {
+				statuses = append(statuses, credsStatus(e.Name, f.Path, c))
+			}
+		}
+	}
+	return statuses
+}
+
+// cacheStatuses describes the credentials process, env and eks token cached.
+func cacheStatuses() []statusOutput {
+	path, err := processCachePath()
+	if err != nil {
+		usageError(err.Error())
+	}
+	data, err := readIfExists(path)
+	if err != nil {
+		logging.Warnf("Could not read cached credentials: %v", err)
+		return nil
+	}
+	objs, err := jsonFormat{}.load(data)
+	if err != nil {
+		logging.Warnf("Ignoring cached credentials in %s: %v", path, err)
+		return nil
+	}
+
+	var names []string
+	for name := range objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var statuses []statusOutput
+	for _, name := range names {
+		c, _ := jsonFormat{}.read(data, targetEntry{Name: name})
+		statuses = append(statuses, credsStatus(name, path, c))
+	}
+	return statuses
+}
+
+// credsStatus describes the credentials c, read from source.
+func credsStatus(name, source string, c jsonCreds) statusOutput {
+	s := statusOutput{
+		Profile:         name,
+		Source:          source,
+		Account:         c.Account,
+		Role:            c.Role,
+		RoleARN:         c.RoleArn,
+		accessKeyID:     c.AccessKeyId,
+		secretAccessKey: c.SecretAccessKey,
+		sessionToken:    c.SessionToken,
+	}
+	s.setExpiration(c.Expiration)
+	return s
+}
+
+func (s *statusOutput) setExpiration(expiration time.Time) {
+	s.Expiration = expiration
+	remaining := time.Until(expiration)
+	s.Expired = remaining <= 0
+	if s.Expired {
+		s.Remaining = "expired"
+	} else {
+		s.Remaining = remaining.Truncate(time.Second).String()
+	}
+}
+
+// verifyStatus calls sts:GetCallerIdentity with the profile's credentials.
+func verifyStatus(s *statusOutput) {
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials(s.accessKeyID, s.secretAccessKey, s.sessionToken)))
+	if err == nil {
+		var out *sts.GetCallerIdentityOutput
+		if out, err = sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err == nil {
+			s.VerifiedARN = aws.StringValue(out.Arn)
+			return
+		}
+	}
+	s.VerifyError = err.Error()
+}
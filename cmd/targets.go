@@ -0,0 +1,644 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"gopkg.in/ini.v1"
+)
+
+// Types of target creds can write credentials to.
+const (
+	targetCredentials = "credentials"
+	targetConfig      = "config"
+	targetJSON        = "json"
+	targetDotenv      = "dotenv"
+	targetDockerEnv   = "docker-env"
+)
+
+// defaultTarget is the built-in target used when none are configured, the
+// credentials file named by --out-file.
+const defaultTarget = "credentials"
+
+var targetFormats = map[string]targetFormat{
+	targetCredentials: iniFormat{},
+	targetConfig:      iniFormat{config: true},
+	targetJSON:        jsonFormat{},
+	targetDotenv:      envFormat{},
+	targetDockerEnv:   envFormat{docker: true},
+}
+
+// A credsTarget is a file creds writes credentials to, configured by a
+// [target.NAME] section. Path and Section are templates executed with the
+// profile.
+type credsTarget struct {
+	Name    string
+	Type    string `mapstructure:"type"`
+	Path    string `mapstructure:"path"`
+	Section string `mapstructure:"name"`
+	Region  string `mapstructure:"region"`
+	Output  string `mapstructure:"output"`
+
+	path, section *template.Template
+	format        targetFormat
+}
+
+// A targetFormat reads and writes one type of target file.
+type targetFormat interface {
+	// expiration returns when e's credentials in data expire, or the zero
+	// time if they're missing.
+	expiration(data []byte, e targetEntry) (time.Time, error)
+	// read returns e's credentials and metadata in data, with a zero
+	// Expiration if they're missing.
+	read(data []byte, e targetEntry) (jsonCreds, error)
+	// write returns data with the entries' credentials added or replaced.
+	write(data []byte, entries []targetEntry) ([]byte, error)
+	// remove returns data without the entries' credentials, or nil if
+	// nothing is left and the file should be removed.
+	remove(data []byte, entries []targetEntry) ([]byte, error)
+}
+
+// profileCreds is a profile and the credentials fetched for it, if any.
+type profileCreds struct {
+	p     profile.Profile
+	creds *sts.Credentials
+}
+
+// A targetEntry is one profile's credentials in a target file.
+type targetEntry struct {
+	Profile *profile.Profile
+	Creds   *sts.Credentials
+	Target  *credsTarget
+	// Name is the rendered section name, JSON key or CUSTS_PROFILE.
+	Name string
+}
+
+// A targetFile is a file written by one or more targets of the same type.
+type targetFile struct {
+	Path    string
+	Type    string
+	Entries []targetEntry
+}
+
+// loadTarget returns the target configured as [target.NAME], or the built-in
+// credentials target.
+func loadTarget(name string) (*credsTarget, error) {
+	t := &credsTarget{Name: name}
+	key := fmt.Sprintf("target.%s", name)
+	switch {
+	case viper.IsSet(key):
+		section := viper.Sub(key)
+		if section == nil {
+			return nil, fmt.Errorf("[%s] is not a table", key)
+		}
+		if err := section.Unmarshal(t); err != nil {
+			return nil, fmt.Errorf("unable to decode [%s]: %v", key, err)
+		}
+	case name == defaultTarget:
+		t.Type = targetCredentials
+	default:
+		return nil, fmt.Errorf("no [%s] in config", key)
+	}
+
+	var ok bool
+	if t.format, ok = targetFormats[t.Type]; !ok {
+		return nil, fmt.Errorf(`unknown type "%s", must be credentials, config, json, dotenv or docker-env`, t.Type)
+	}
+	if t.Path == "" {
+		switch t.Type {
+		case targetCredentials:
+			t.Path = outFile
+		case targetConfig:
+			t.Path = "~/.aws/config"
+		default:
+			return nil, fmt.Errorf(`missing required key "path"`)
+		}
+	}
+	if t.Section == "" {
+		t.Section = "{{.Name}}"
+	}
+
+	var err error
+	if t.path, err = template.New("path").Parse(t.Path); err != nil {
+		return nil, err
+	}
+	if t.section, err = template.New("name").Parse(t.Section); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadTargets loads the targets of profiles into credsTargets, returning the
+// profiles for planTargets.
+func loadTargets(profiles []profile.Profile) ([]profileCreds, error) {
+	credsTargets = make(map[string]*credsTarget)
+	var planned []profileCreds
+	for _, p := range profiles {
+		for _, name := range profileTargets(&p) {
+			if _, ok := credsTargets[name]; ok {
+				continue
+			}
+			t, err := loadTarget(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target %s: %v", name, err)
+			}
+			credsTargets[name] = t
+		}
+		planned = append(planned, profileCreds{p: p})
+	}
+	return planned, nil
+}
+
+// configuredTargetFiles plans the targets of every profile in the config file,
+// for the commands that read or remove what creds wrote. Profiles that don't
+// load are skipped with a warning.
+func configuredTargetFiles() ([]*targetFile, error) {
+	var names []string
+	for name := range profile.Profiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var profiles []profile.Profile
+	for _, name := range names {
+		p, err := profile.NewFromConfig(name)
+		if err != nil {
+			logging.Warnf("Skipping the targets of %s: %v", name, err)
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	planned, err := loadTargets(profiles)
+	if err != nil {
+		return nil, err
+	}
+	return planTargets(planned)
+}
+
+// profileTargets returns the names of the targets p's credentials are written
+// to: --targets, the profile's targets, the top-level targets or the built-in
+// credentials target.
+func profileTargets(p *profile.Profile) []string {
+	switch {
+	case len(targetsFlag) > 0:
+		return targetsFlag
+	case len(p.Targets) > 0:
+		return p.Targets
+	case len(viper.GetStringSlice("targets")) > 0:
+		return viper.GetStringSlice("targets")
+	}
+	return []string{defaultTarget}
+}
+
+// planTargets groups the profiles' entries by the file their targets render
+// to, in order. Targets sharing a file must have the same type, and may not
+// write the same name for different profiles.
+func planTargets(results []profileCreds) ([]*targetFile, error) {
+	var files []*targetFile
+	byPath := make(map[string]*targetFile)
+	for i := range results {
+		p := &results[i].p
+		for _, name := range profileTargets(p) {
+			t := credsTargets[name]
+			path, err := renderTarget(t.path, p)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: path: %v", name, err)
+			}
+			path, _ = homedir.Expand(path)
+			path = filepath.Clean(path)
+			e := targetEntry{Profile: p, Creds: results[i].creds, Target: t}
+			if e.Name, err = renderTarget(t.section, p); err != nil {
+				return nil, fmt.Errorf("target %s: name: %v", name, err)
+			}
+
+			f, ok := byPath[path]
+			if !ok {
+				f = &targetFile{Path: path, Type: t.Type}
+				byPath[path] = f
+				files = append(files, f)
+			}
+			if f.Type != t.Type {
+				return nil, fmt.Errorf("%s is written as both %s and %s", path, f.Type, t.Type)
+			}
+			for _, other := range f.Entries {
+				if other.Name == e.Name && other.Profile.Name != p.Name {
+					return nil, fmt.Errorf("profiles %s and %s both write %s to %s", other.Profile.Name, p.Name, e.Name, path)
+				}
+			}
+			if len(f.Entries) > 0 && (t.Type == targetDotenv || t.Type == targetDockerEnv) {
+				return nil, fmt.Errorf("profiles %s and %s both write %s, which holds one profile", f.Entries[0].Profile.Name, p.Name, path)
+			}
+			f.Entries = append(f.Entries, e)
+		}
+	}
+	return files, nil
+}
+
+// renderTarget executes a target template with p.
+func renderTarget(t *template.Template, p *profile.Profile) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("%s renders empty", t.Name())
+	}
+	return buf.String(), nil
+}
+
+// targetExpirations reads the files without locking them and returns the
+// earliest expiration of each profile's credentials across its targets.
+func targetExpirations(files []*targetFile) (map[string]time.Time, error) {
+	expirations := make(map[string]time.Time)
+	for _, f := range files {
+		data, err := readIfExists(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range f.Entries {
+			expiration, err := e.Target.format.expiration(data, e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.Path, err)
+			}
+			if current, ok := expirations[e.Profile.Name]; !ok || expiration.Before(current) {
+				expirations[e.Profile.Name] = expiration
+			}
+		}
+	}
+	return expirations, nil
+}
+
+// writeTargetFile locks f's file and writes its entries' credentials.
+func writeTargetFile(f *targetFile) error {
+	lf, err := lockFilePath(f.Path)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	data, err := lf.Read()
+	if err != nil {
+		return err
+	}
+	if data, err = targetFormats[f.Type].write(data, f.Entries); err != nil {
+		return err
+	}
+	return lf.Replace(data)
+}
+
+// iniFormat writes AWS shared credentials files, or with config the AWS config
+// file's [profile NAME] sections.
+type iniFormat struct {
+	config bool
+}
+
+func (f iniFormat) section(name string) string {
+	if f.config && name != "default" {
+		return "profile " + name
+	}
+	return name
+}
+
+func (f iniFormat) expiration(data []byte, e targetEntry) (time.Time, error) {
+	c, err := f.read(data, e)
+	return c.Expiration, err
+}
+
+func (f iniFormat) read(data []byte, e targetEntry) (jsonCreds, error) {
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return jsonCreds{}, err
+	}
+	sect, err := cfg.GetSection(f.section(e.Name))
+	if err != nil {
+		return jsonCreds{}, nil
+	}
+	return jsonCreds{
+		Version:         1,
+		AccessKeyId:     sect.Key("aws_access_key_id").String(),
+		SecretAccessKey: sect.Key("aws_secret_access_key").String(),
+		SessionToken:    sect.Key("aws_session_token").String(),
+		Expiration:      sectionExpiration(sect),
+		Account:         sect.Key(metaAccount).String(),
+		Role:            sect.Key(metaRole).String(),
+		RoleArn:         sect.Key(metaRoleARN).String(),
+	}, nil
+}
+
+// iniParts are an INI file's sections outside the block config export-aws
+// manages in the AWS config file, and the block itself, which the config
+// target leaves as it is.
+type iniParts struct {
+	before, after *ini.File
+	block         []string
+	managed       map[string]bool
+}
+
+// load parses data, splitting out export-aws's block for the config file.
+func (f iniFormat) load(data []byte) (*iniParts, error) {
+	parts := &iniParts{after: ini.Empty(), managed: make(map[string]bool)}
+	if !f.config {
+		var err error
+		parts.before, err = ini.Load(data)
+		return parts, err
+	}
+
+	before, after, err := splitManaged(data)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	parts.block = lines[len(before) : len(lines)-len(after)]
+	for _, line := range parts.block {
+		if m := sectionHeader.FindStringSubmatch(line); m != nil {
+			parts.managed[strings.Join(strings.Fields(m[1]), " ")] = true
+		}
+	}
+	if parts.before, err = ini.Load([]byte(strings.Join(before, "\n"))); err != nil {
+		return nil, err
+	}
+	if parts.after, err = ini.Load([]byte(strings.Join(after, "\n"))); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// section returns the named section outside the managed block, or nil.
+func (p *iniParts) section(name string) *ini.Section {
+	for _, cfg := range []*ini.File{p.before, p.after} {
+		if sect, err := cfg.GetSection(name); err == nil {
+			return sect
+		}
+	}
+	return nil
+}
+
+func (p *iniParts) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := p.before.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	if len(p.block) > 0 {
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n\n")) {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(strings.Join(p.block, ""))
+		buf.WriteString("\n")
+	}
+	if _, err := p.after.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f iniFormat) write(data []byte, entries []targetEntry) ([]byte, error) {
+	parts, err := f.load(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := f.section(e.Name)
+		if parts.managed[name] {
+			return nil, fmt.Errorf("[%s] is managed by config export-aws, not replacing it", name)
+		}
+		sect := parts.section(name)
+		switch {
+		case sect == nil && len(parts.block) > 0:
+			sect = parts.after.Section(name)
+		case sect == nil:
+			sect = parts.before.Section(name)
+		case f.config && len(sect.Keys()) > 0 && !sect.HasKey(metaExpiration):
+			// the AWS config file's profiles hold the user's own settings
+			return nil, fmt.Errorf("[%s] wasn't written by cu-sts, not replacing it", name)
+		}
+		// Clear any current keys to make sure we don't accidentaly carry over anything extra
+		for _, k := range sect.KeyStrings() {
+			sect.DeleteKey(k)
+		}
+		sect.Key("aws_access_key_id").SetValue(*e.Creds.AccessKeyId)
+		sect.Key("aws_secret_access_key").SetValue(*e.Creds.SecretAccessKey)
+		sect.Key("aws_session_token").SetValue(*e.Creds.SessionToken)
+		sect.Key("aws_security_token").SetValue(*e.Creds.SessionToken)
+		if f.config && e.Target.Region != "" {
+			sect.Key("region").SetValue(e.Target.Region)
+		}
+		if f.config && e.Target.Output != "" {
+			sect.Key("output").SetValue(e.Target.Output)
+		}
+		setMetadata(sect, e.Profile, e.Creds)
+	}
+	return parts.bytes()
+}
+
+func (f iniFormat) remove(data []byte, entries []targetEntry) ([]byte, error) {
+	parts, err := f.load(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := f.section(e.Name)
+		for _, cfg := range []*ini.File{parts.before, parts.after} {
+			if sect, err := cfg.GetSection(name); err == nil && sect.HasKey(metaExpiration) {
+				cfg.DeleteSection(name)
+			}
+		}
+	}
+	return parts.bytes()
+}
+
+// jsonFormat writes a JSON object of credentials keyed by name, each in the
+// AWS credential_process format.
+type jsonFormat struct{}
+
+// jsonCreds is one profile's credentials in a json target.
+type jsonCreds struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+	Account         string
+	Role            string
+	RoleArn         string `json:",omitempty"`
+}
+
+// newJSONCreds returns p's credentials in the credential_process format.
+func newJSONCreds(p *profile.Profile, creds *sts.Credentials) jsonCreds {
+	return jsonCreds{
+		Version:         1,
+		AccessKeyId:     *creds.AccessKeyId,
+		SecretAccessKey: *creds.SecretAccessKey,
+		SessionToken:    *creds.SessionToken,
+		Expiration:      creds.Expiration.UTC(),
+		Account:         p.Account,
+		Role:            p.Role,
+		RoleArn:         p.AssumedRoleARN,
+	}
+}
+
+func (jsonFormat) load(data []byte) (map[string]json.RawMessage, error) {
+	objs := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return objs, nil
+	}
+	if err := json.Unmarshal(data, &objs); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %v", err)
+	}
+	return objs, nil
+}
+
+func (f jsonFormat) expiration(data []byte, e targetEntry) (time.Time, error) {
+	c, err := f.read(data, e)
+	return c.Expiration, err
+}
+
+func (f jsonFormat) read(data []byte, e targetEntry) (jsonCreds, error) {
+	objs, err := f.load(data)
+	if err != nil {
+		return jsonCreds{}, err
+	}
+	var c jsonCreds
+	if raw, ok := objs[e.Name]; ok {
+		json.Unmarshal(raw, &c)
+	}
+	return c, nil
+}
+
+func (f jsonFormat) write(data []byte, entries []targetEntry) ([]byte, error) {
+	objs, err := f.load(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if objs[e.Name], err = json.Marshal(newJSONCreds(e.Profile, e.Creds)); err != nil {
+			return nil, err
+		}
+	}
+	out, err := json.MarshalIndent(objs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func (f jsonFormat) remove(data []byte, entries []targetEntry) ([]byte, error) {
+	objs, err := f.load(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		delete(objs, e.Name)
+	}
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	out, err := json.MarshalIndent(objs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// envFormat writes one profile's credentials as environment variables, quoted
+// for dotenv loaders or, with docker, unquoted for docker run --env-file.
+type envFormat struct {
+	docker bool
+}
+
+func (f envFormat) expiration(data []byte, e targetEntry) (time.Time, error) {
+	c, err := f.read(data, e)
+	return c.Expiration, err
+}
+
+func (f envFormat) read(data []byte, e targetEntry) (jsonCreds, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := kv[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		vars[kv[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return jsonCreds{}, err
+	}
+
+	expiration, _ := time.Parse(time.RFC3339, vars["CUSTS_EXPIRATION"])
+	return jsonCreds{
+		Version:         1,
+		AccessKeyId:     vars["AWS_ACCESS_KEY_ID"],
+		SecretAccessKey: vars["AWS_SECRET_ACCESS_KEY"],
+		SessionToken:    vars["AWS_SESSION_TOKEN"],
+		Expiration:      expiration,
+		Account:         vars["CUSTS_ACCOUNT"],
+		Role:            vars["CUSTS_ROLE"],
+		RoleArn:         vars["CUSTS_ROLE_ARN"],
+	}, nil
+}
+
+func (f envFormat) write(data []byte, entries []targetEntry) ([]byte, error) {
+	e := entries[0]
+	vars := [][2]string{
+		{"AWS_ACCESS_KEY_ID", *e.Creds.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", *e.Creds.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", *e.Creds.SessionToken},
+		{"AWS_SECURITY_TOKEN", *e.Creds.SessionToken},
+	}
+	if e.Target.Region != "" {
+		vars = append(vars, [2]string{"AWS_REGION", e.Target.Region}, [2]string{"AWS_DEFAULT_REGION", e.Target.Region})
+	}
+	if e.Target.Output != "" {
+		vars = append(vars, [2]string{"AWS_DEFAULT_OUTPUT", e.Target.Output})
+	}
+	vars = append(vars,
+		[2]string{"CUSTS_PROFILE", e.Name},
+		[2]string{"CUSTS_ACCOUNT", e.Profile.Account},
+		[2]string{"CUSTS_ROLE", e.Profile.Role},
+		[2]string{"CUSTS_ROLE_ARN", e.Profile.AssumedRoleARN},
+		[2]string{"CUSTS_EXPIRATION", aws.TimeValue(e.Creds.Expiration).UTC().Format(time.RFC3339)},
+	)
+	if e.Profile.Protected {
+		vars = append(vars, [2]string{"CUSTS_PROTECTED", "1"})
+	}
+
+	var buf bytes.Buffer
+	for _, kv := range vars {
+		value := kv[1]
+		if !f.docker {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", kv[0], value)
+	}
+	return buf.Bytes(), nil
+}
+
+func (f envFormat) remove(data []byte, entries []targetEntry) ([]byte, error) {
+	c, err := f.read(data, entries[0])
+	if err != nil || c.Expiration.IsZero() {
+		return data, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/viper"
+)
+
+// testEntry returns an entry with credentials for the named profile.
+func testEntry(name string, t *credsTarget) targetEntry {
+	return targetEntry{
+		Profile: &profile.Profile{Name: name, Account: "123456789012", Role: "shib-admin"},
+		Creds: &sts.Credentials{
+			AccessKeyId:     aws.String("AKIA" + strings.ToUpper(name)),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+		Target: t,
+		Name:   name,
+	}
+}
+
+// withoutIssued drops the issue time, which changes between writes.
+func withoutIssued(data []byte) string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, metaIssued) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestIniFormatWrite(t *testing.T) {
+	const block = exportBegin + `
+[profile dev]
+credential_process = cu-sts process --quiet --profile dev
+region = us-east-1
+` + exportEnd + `
+`
+	tests := []struct {
+		name    string
+		config  bool
+		data    string
+		want    []string
+		notWant []string
+		wantErr string
+	}{
+		{
+			name:   "new file",
+			config: true,
+			want:   []string{"[profile admin]", "aws_access_key_id     = AKIAADMIN", metaExpiration},
+		},
+		{
+			name:    "user profile",
+			config:  true,
+			data:    "[profile admin]\nsso_start_url = https://example.com\nregion = us-east-1\n",
+			wantErr: "wasn't written by cu-sts",
+		},
+		{
+			name:   "cu-sts profile",
+			config: true,
+			data: "[profile other]\nrole_arn = arn:aws:iam::1:role/x\n\n" +
+				"[profile admin]\naws_access_key_id = OLD\nx_custs_expiration = 2021-01-01T00:00:00Z\n",
+			want:    []string{"role_arn = arn:aws:iam::1:role/x", "AKIAADMIN"},
+			notWant: []string{"OLD"},
+		},
+		{
+			name:   "keeps managed block",
+			config: true,
+			data:   "[default]\nregion = us-east-2\n\n" + block + "\n[profile late]\nregion = eu-west-1\n",
+			want: []string{
+				"region = us-east-2",
+				block,
+				"[profile late]",
+				"AKIAADMIN",
+			},
+		},
+		{
+			name:    "managed profile",
+			config:  true,
+			data:    strings.Replace(block, "profile dev", "profile admin", 1),
+			wantErr: "managed by config export-aws",
+		},
+		{
+			name:    "credentials file replaces any section",
+			data:    "[admin]\naws_access_key_id = OLD\n",
+			want:    []string{"AKIAADMIN"},
+			notWant: []string{"OLD"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := iniFormat{config: tt.config}
+			e := testEntry("admin", &credsTarget{Type: targetConfig})
+			got, err := f.write([]byte(tt.data), []targetEntry{e})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("write() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(got), s) {
+					t.Errorf("write() = %q, missing %q", got, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(string(got), s) {
+					t.Errorf("write() = %q, contains %q", got, s)
+				}
+			}
+
+			// writing again must be stable and removing must restore the rest
+			again, err := f.write(got, []targetEntry{e})
+			if err != nil {
+				t.Fatalf("second write() error = %v", err)
+			}
+			if withoutIssued(again) != withoutIssued(got) {
+				t.Errorf("second write() = %q, want %q", again, got)
+			}
+			removed, err := f.remove(got, []targetEntry{e})
+			if err != nil {
+				t.Fatalf("remove() error = %v", err)
+			}
+			if strings.Contains(string(removed), "AKIAADMIN") {
+				t.Errorf("remove() = %q, still has the credentials", removed)
+			}
+			if strings.Contains(tt.data, exportBegin) && !strings.Contains(string(removed), block) {
+				t.Errorf("remove() = %q, lost the managed block", removed)
+			}
+		})
+	}
+}
+
+func TestEnvFormatExpiration(t *testing.T) {
+	e := testEntry("admin", &credsTarget{Type: targetDotenv})
+	tests := []struct {
+		name    string
+		docker  bool
+		written bool
+		data    string
+	}{
+		{name: "empty"},
+		{name: "dotenv", written: true},
+		{name: "docker-env", docker: true, written: true},
+		{name: "user file", data: "# my vars\nFOO=bar\nexport X\n"},
+		{name: "bad expiration", data: "AWS_ACCESS_KEY_ID=AKIA\nCUSTS_EXPIRATION=soon\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := envFormat{docker: tt.docker}
+			data := []byte(tt.data)
+			var want time.Time
+			if tt.written {
+				var err error
+				if data, err = f.write(nil, []targetEntry{e}); err != nil {
+					t.Fatalf("write() error = %v", err)
+				}
+				want = e.Creds.Expiration.Truncate(time.Second)
+			}
+			got, err := f.expiration(data, e)
+			if err != nil {
+				t.Fatalf("expiration() error = %v", err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("expiration() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPlanTargets(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("target", map[string]interface{}{
+		"shared-a": map[string]interface{}{"type": "json", "path": "/tmp/creds.json"},
+		"shared-b": map[string]interface{}{"type": "json", "path": "/tmp/creds.json"},
+		"fixed":    map[string]interface{}{"type": "json", "path": "/tmp/creds.json", "name": "current"},
+		"other":    map[string]interface{}{"type": "credentials", "path": "/tmp/creds.json"},
+		"env":      map[string]interface{}{"type": "dotenv", "path": "/tmp/{{.Account}}.env"},
+	})
+
+	tests := []struct {
+		name      string
+		targets   [][]string
+		wantFiles int
+		wantErr   string
+	}{
+		{"shared file", [][]string{{"shared-a"}, {"shared-b"}}, 1, ""},
+		{"same profile twice", [][]string{{"shared-a", "shared-b"}}, 1, ""},
+		{"type conflict", [][]string{{"shared-a"}, {"other"}}, 0, "written as both"},
+		{"name conflict", [][]string{{"fixed"}, {"fixed"}}, 0, "both write current"},
+		{"one profile per env file", [][]string{{"env"}, {"env"}}, 0, "holds one profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var profiles []profile.Profile
+			for i, targets := range tt.targets {
+				profiles = append(profiles, profile.Profile{
+					Name:    fmt.Sprintf("p%d", i),
+					Account: "123456789012",
+					Targets: targets,
+				})
+			}
+			planned, err := loadTargets(profiles)
+			if err != nil {
+				t.Fatalf("loadTargets() error = %v", err)
+			}
+			files, err := planTargets(planned)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planTargets() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planTargets() error = %v", err)
+			}
+			if len(files) != tt.wantFiles {
+				t.Errorf("planTargets() = %d files, want %d", len(files), tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestSectionProfiles(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("target", map[string]interface{}{
+		"credentials": map[string]interface{}{"type": "credentials", "name": "cu-{{.Name}}"},
+		"js":          map[string]interface{}{"type": "json", "path": "/tmp/creds.json"},
+	})
+	outFile = "/tmp/credentials"
+	defer func() { outFile = "" }()
+
+	planned, err := loadTargets([]profile.Profile{
+		{Name: "admin", Targets: []string{"credentials", "js"}},
+		{Name: "dev", Targets: []string{"js"}},
+	})
+	if err != nil {
+		t.Fatalf("loadTargets() error = %v", err)
+	}
+	files, err := planTargets(planned)
+	if err != nil {
+		t.Fatalf("planTargets() error = %v", err)
+	}
+	sections := sectionProfiles(files)
+
+	tests := []struct {
+		section string
+		want    string
+	}{
+		{"cu-admin", "admin"},
+		// only the credentials file's sections are mapped
+		{"dev", "dev"},
+		{"mine", "mine"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.section, func(t *testing.T) {
+			if got := sectionProfile(sections, tt.section); got != tt.want {
+				t.Errorf("sectionProfile(%s) = %s, want %s", tt.section, got, tt.want)
+			}
+		})
+	}
+}
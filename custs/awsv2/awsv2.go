@@ -0,0 +1,52 @@
+// Package awsv2 adapts custs.Provider to aws-sdk-go-v2, for services built on
+// the v2 SDK:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx,
+//		config.WithCredentialsProvider(aws.NewCredentialsCache(awsv2.NewProvider("admin"))),
+//	)
+//
+// aws.CredentialsCache refreshes the credentials before they expire, see its
+// ExpiryWindow option.
+package awsv2
+
+import (
+	"context"
+
+	"cu-sts/custs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Provider is an aws.CredentialsProvider for a profile in the cu-sts config
+// file.
+type Provider struct {
+	provider *custs.Provider
+}
+
+// NewProvider returns a Provider for the named profile, logging in with a
+// custs.Client configured by opts.
+func NewProvider(name string, opts ...custs.Option) Provider {
+	return Provider{provider: custs.NewProvider(name, opts...)}
+}
+
+// FromProvider adapts p, e.g. one whose Config was loaded with
+// custs.LoadConfig.
+func FromProvider(p *custs.Provider) Provider {
+	return Provider{provider: p}
+}
+
+// Retrieve implements aws.CredentialsProvider.
+func (p Provider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.RetrieveSTS(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		Source:          custs.ProviderName,
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package custs
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"cu-sts/idp"
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+// LoadConfig reads the cu-sts config file at path, by default ~/.cu-sts.toml,
+// into a new viper instance for Settings and a Provider's Config. Like the
+// cu-sts CLI, CUSTS_ environment variables override its keys. The global
+// viper instance is left alone.
+func LoadConfig(path string) (*viper.Viper, error) {
+	v := viper.New()
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+		}
+		v.AddConfigPath(home)
+		v.SetConfigName(".cu-sts")
+	} else {
+		v.SetConfigFile(path)
+	}
+	v.SetEnvPrefix("CUSTS")
+	v.AutomaticEnv()
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("%w: unable to read config: %v", ErrInvalidOptions, err)
+	}
+	return v, nil
+}
+
+// Settings returns the login settings for p's identity from the config in v,
+// the way the cu-sts CLI builds them. Keys v doesn't set keep the values of
+// idp.DefaultSettings, and debug snapshots go to debug/ in the cache directory
+// unless debug_dir is set.
+func Settings(v *viper.Viper, p *profile.Profile) (idp.Settings, error) {
+	s := idp.DefaultSettings()
+	if p.DuoMethod != "" {
+		s.DuoMethod = p.DuoMethod
+	}
+	seconds := map[string]*time.Duration{
+		"timeout":                  &s.Timeout,
+		"login_timeout":            &s.LoginTimeout,
+		"duo_timeout":              &s.DuoTimeout,
+		"duo_frame_timeout":        &s.DuoFrameTimeout,
+		"password_command_timeout": &s.PasswordCommandTimeout,
+	}
+	for key, d := range seconds {
+		if n := v.GetInt(key); n > 0 {
+			*d = time.Duration(n) * time.Second
+		}
+	}
+	s.DuoRetries = v.GetInt("duo_retries")
+	s.DuoFallback = v.GetString("duo_fallback")
+	s.DuoRemember = v.GetBool("duo_remember")
+	if b := v.GetString("browser"); b != "" {
+		s.Browser = b
+	}
+	s.PasswordCommand = v.GetString("password_command")
+	s.PasswordCommandShell = v.GetBool("password_command_shell")
+
+	if dir := v.GetString("browser_profile_dir"); dir != "" {
+		expanded, err := homedir.Expand(dir)
+		if err != nil {
+			return s, fmt.Errorf("%w: unable to expand browser_profile_dir %s: %v", ErrInvalidOptions, dir, err)
+		}
+		s.BrowserProfileDir = expanded
+	}
+
+	cacheDir, cacheErr := profile.ConfigCacheDir(v)
+	if v.GetBool("session_cookies") {
+		if cacheErr != nil {
+			logging.Warnf("Not using stored IdP session: %v", cacheErr)
+		}
+		s.CookieDir = cacheDir
+	}
+
+	if dir := v.GetString("debug_dir"); dir != "" {
+		expanded, err := homedir.Expand(dir)
+		if err != nil {
+			return s, fmt.Errorf("%w: unable to expand debug_dir %s: %v", ErrInvalidOptions, dir, err)
+		}
+		s.DebugDir = expanded
+	} else if cacheErr == nil {
+		s.DebugDir = filepath.Join(cacheDir, "debug")
+	}
+
+	if path := v.GetString("flow_file"); path != "" {
+		expanded, err := homedir.Expand(path)
+		if err != nil {
+			return s, fmt.Errorf("%w: unable to expand flow_file %s: %v", ErrInvalidOptions, path, err)
+		}
+		if s.Flow, err = idp.LoadFlow(expanded); err != nil {
+			return s, fmt.Errorf("%w: could not use flow_file: %v", ErrInvalidOptions, err)
+		}
+	}
+	return s, nil
+}
+
+// Password returns the password set in the config in v if p logs in as the
+// config's username, or "".
+func Password(v *viper.Viper, p *profile.Profile) string {
+	if p.Username != v.GetString("username") {
+		return ""
+	}
+	return v.GetString("password")
+}
@@ -0,0 +1,105 @@
+package custs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cu-sts/idp"
+	"cu-sts/profile"
+
+	"github.com/spf13/viper"
+)
+
+func TestSettings(t *testing.T) {
+	defaults := idp.DefaultSettings()
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		check  func(s idp.Settings) bool
+	}{
+		{
+			name:  "defaults",
+			check: func(s idp.Settings) bool { return s.Timeout == defaults.Timeout && s.DuoMethod == "call" },
+		},
+		{
+			name:   "timeouts",
+			config: map[string]interface{}{"timeout": 300, "duo_frame_timeout": 5},
+			check: func(s idp.Settings) bool {
+				return s.Timeout == 300*time.Second && s.DuoFrameTimeout == 5*time.Second && s.LoginTimeout == defaults.LoginTimeout
+			},
+		},
+		{
+			name:   "password command",
+			config: map[string]interface{}{"password_command": "pass show netid", "password_command_timeout": 10},
+			check: func(s idp.Settings) bool {
+				return s.PasswordCommand == "pass show netid" && s.PasswordCommandTimeout == 10*time.Second
+			},
+		},
+		{
+			name:   "debug dir defaults to the cache directory",
+			config: map[string]interface{}{"cache_dir": "/tmp/cu-sts-cache"},
+			check:  func(s idp.Settings) bool { return s.DebugDir == filepath.Join("/tmp/cu-sts-cache", "debug") },
+		},
+		{
+			name:   "debug dir",
+			config: map[string]interface{}{"debug_dir": "/tmp/cu-sts-debug"},
+			check:  func(s idp.Settings) bool { return s.DebugDir == "/tmp/cu-sts-debug" },
+		},
+		{
+			name:   "browser profile dir is used as is",
+			config: map[string]interface{}{"browser_profile_dir": "/tmp/chrome"},
+			check:  func(s idp.Settings) bool { return s.BrowserProfileDir == "/tmp/chrome" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			for k, value := range tt.config {
+				v.Set(k, value)
+			}
+			p := profile.Profile{Username: "netid", DuoMethod: "call"}
+			s, err := Settings(v, &p)
+			if err != nil {
+				t.Fatalf("Settings() error = %v", err)
+			}
+			if !tt.check(s) {
+				t.Errorf("Settings() = %+v", s)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "cu-sts-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("username = \"netid\"\npassword_command = \"pass show netid\"\n\n[profile.admin]\naccount = \"123456789012\"\nrole = \"shib-admin\"\n")
+	f.Close()
+
+	v, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if viper.GetString("password_command") != "" {
+		t.Errorf("LoadConfig() changed the global viper instance")
+	}
+	p, err := profile.FromConfig(v, "admin")
+	if err != nil {
+		t.Fatalf("FromConfig() error = %v", err)
+	}
+	if p.Username != "netid" || p.Duration != 3600 || p.IDProvider != "cornell_idp" {
+		t.Errorf("FromConfig() = %+v", p)
+	}
+	s, err := Settings(v, &p)
+	if err != nil {
+		t.Fatalf("Settings() error = %v", err)
+	}
+	if s.PasswordCommand != "pass show netid" {
+		t.Errorf("Settings() PasswordCommand = %q", s.PasswordCommand)
+	}
+}
@@ -0,0 +1,180 @@
+// Package custs is the Go API for cu-sts logins, for tools that want to embed
+// them. A Client logs in to the IdP with the idp package and exchanges the
+// SAML assertion for STS credentials with the profile package. It never exits
+// the process or installs signal handlers: cancel the context instead.
+//
+// Diagnostics go to the logging package, which writes to STDERR unless
+// logging.SetOutput says otherwise.
+package custs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cu-sts/audit"
+	"cu-sts/idp"
+	"cu-sts/logging"
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// ErrInvalidOptions is returned when a login can't start because of the
+// Client's options or the profile, e.g. an unknown DUO method.
+var ErrInvalidOptions = errors.New("invalid login options")
+
+// A Client logs in to the IdP and fetches STS credentials. It is safe to use
+// from multiple goroutines, as long as they don't share a browser profile
+// directory.
+type Client struct {
+	settings idp.Settings
+	password string
+	audit    AuditFunc
+}
+
+// An AuditFunc records credentials a Client issued, e.g. audit.Log.
+type AuditFunc func(audit.Record) error
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// NewClient returns a Client using idp.DefaultSettings changed by opts.
+func NewClient(opts ...Option) *Client {
+	c := &Client{settings: idp.DefaultSettings()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithSettings replaces all of the Client's login settings.
+func WithSettings(s idp.Settings) Option {
+	return func(c *Client) {
+		c.settings = s
+	}
+}
+
+// WithDuoMethod sets the DUO method, "push" or "call".
+func WithDuoMethod(method string) Option {
+	return func(c *Client) {
+		c.settings.DuoMethod = method
+	}
+}
+
+// WithTimeout bounds each login, including waiting for DUO.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.settings.Timeout = d
+	}
+}
+
+// WithFlow replaces the built-in Cornell login flow.
+func WithFlow(f idp.Flow) Option {
+	return func(c *Client) {
+		c.settings.Flow = f
+	}
+}
+
+// WithBrowserProfileDir keeps a persistent Chrome profile in dir.
+func WithBrowserProfileDir(dir string) Option {
+	return func(c *Client) {
+		c.settings.BrowserProfileDir = dir
+	}
+}
+
+// WithPassword sets the password, so it is never asked for.
+func WithPassword(password string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithPrompt sets the function asked for the password when it is needed,
+// instead of prompting on the terminal.
+func WithPrompt(prompt idp.PromptFunc) Option {
+	return func(c *Client) {
+		c.settings.Prompt = prompt
+	}
+}
+
+// WithMFA sets a function told about the DUO step, e.g. to ask the user to
+// approve the push.
+func WithMFA(onMFA func(idp.MFAEvent)) Option {
+	return func(c *Client) {
+		c.settings.OnMFA = onMFA
+	}
+}
+
+// WithAudit records every set of credentials the Client issues with log, such
+// as audit.Log once audit.SetFile has been called. A failure to record is
+// logged as a warning and doesn't fail the login.
+func WithAudit(log AuditFunc) Option {
+	return func(c *Client) {
+		c.audit = log
+	}
+}
+
+// WithDebug sends Chrome's debug output, redacted, to the logging package.
+func WithDebug(debug bool) Option {
+	return func(c *Client) {
+		c.settings.Debug = debug
+	}
+}
+
+// SAMLResponse logs in as username and returns the base-64 SAML assertion.
+func (c *Client) SAMLResponse(ctx context.Context, username string) (string, error) {
+	return c.login(ctx, username, c.settings)
+}
+
+// Credentials logs in as p's Username, with its DuoMethod if set, and returns
+// STS credentials for p's role. It sets p's AssumedRoleARN.
+func (c *Client) Credentials(ctx context.Context, p *profile.Profile) (*sts.Credentials, error) {
+	s := c.settings
+	if p.DuoMethod != "" {
+		s.DuoMethod = p.DuoMethod
+	}
+	assertion, err := c.login(ctx, p.Username, s)
+	if err != nil {
+		return nil, err
+	}
+	return c.Exchange(ctx, p, assertion)
+}
+
+// Exchange returns STS credentials for p's role from an assertion that
+// SAMLResponse returned, e.g. to use one login for several profiles. It sets
+// p's AssumedRoleARN.
+func (c *Client) Exchange(ctx context.Context, p *profile.Profile, assertion string) (*sts.Credentials, error) {
+	creds, err := p.CredentialsWithContext(ctx, assertion)
+	if err != nil || c.audit == nil {
+		return creds, err
+	}
+
+	err = c.audit(audit.Record{
+		Username:    p.Username,
+		Profile:     p.Name,
+		Account:     p.Account,
+		Alias:       p.Alias,
+		Role:        p.Role,
+		RoleARN:     p.AssumedRoleARN,
+		AccessKeyID: aws.StringValue(creds.AccessKeyId),
+		Expiration:  aws.TimeValue(creds.Expiration).UTC(),
+		Protected:   p.Protected,
+	})
+	if err != nil {
+		logging.Warnf("Could not write audit log: %v", err)
+	}
+	return creds, nil
+}
+
+func (c *Client) login(ctx context.Context, username string, s idp.Settings) (string, error) {
+	if username == "" {
+		return "", fmt.Errorf("%w: no username", ErrInvalidOptions)
+	}
+	if s.DuoMethod != "push" && s.DuoMethod != "call" {
+		return "", fmt.Errorf("%w: unknown DUO method %s, must be push or call", ErrInvalidOptions, s.DuoMethod)
+	}
+	return idp.Login(ctx, username, c.password, s)
+}
@@ -0,0 +1,114 @@
+package custs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cu-sts/profile"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/viper"
+)
+
+// ProviderName is the credentials.Value ProviderName of a Provider.
+const ProviderName = "CustsProvider"
+
+// DefaultExpiryWindow is how long before they expire a Provider's credentials
+// are refreshed.
+const DefaultExpiryWindow = 5 * time.Minute
+
+// A Provider is an aws-sdk-go credentials.Provider for a profile in the cu-sts
+// config file, which logs in again whenever the credentials are about to
+// expire:
+//
+//	sess := session.Must(session.NewSession(&aws.Config{
+//		Credentials: credentials.NewCredentials(custs.NewProvider("admin")),
+//	}))
+//
+// The profile and the login settings come from Config, like they do for the
+// cu-sts CLI, and opts are applied on top. Credentials that `cu-sts process`
+// or `cu-sts env` cached for the profile are used without logging in while
+// they're valid for longer than ExpiryWindow.
+type Provider struct {
+	credentials.Expiry
+
+	// Profile is the name of the [profile.x] section to use.
+	Profile string
+	// ExpiryWindow refreshes the credentials this long before they expire,
+	// so requests in flight don't fail.
+	ExpiryWindow time.Duration
+	// Config is the cu-sts config, see LoadConfig. If nil, the first
+	// Retrieve loads ~/.cu-sts.toml.
+	Config *viper.Viper
+
+	opts []Option
+	mu   sync.Mutex
+}
+
+// NewProvider returns a Provider for the named profile, logging in with a
+// Client configured by the config file and then opts.
+func NewProvider(name string, opts ...Option) *Provider {
+	return &Provider{
+		Profile:      name,
+		ExpiryWindow: DefaultExpiryWindow,
+		opts:         opts,
+	}
+}
+
+// Retrieve implements credentials.Provider.
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+// RetrieveWithContext implements credentials.ProviderWithContext.
+func (p *Provider) RetrieveWithContext(ctx aws.Context) (credentials.Value, error) {
+	creds, err := p.RetrieveSTS(ctx)
+	if err != nil {
+		return credentials.Value{ProviderName: ProviderName}, err
+	}
+	p.SetExpiration(aws.TimeValue(creds.Expiration), p.ExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+		ProviderName:    ProviderName,
+	}, nil
+}
+
+// RetrieveSTS returns the profile's cached STS credentials, or logs in for new
+// ones. It is the SDK-independent part of Retrieve, e.g. for other SDK
+// adapters.
+func (p *Provider) RetrieveSTS(ctx context.Context) (*sts.Credentials, error) {
+	// one login at a time, the SDK may retrieve from several goroutines
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Config == nil {
+		v, err := LoadConfig("")
+		if err != nil {
+			return nil, err
+		}
+		p.Config = v
+	}
+	prof, err := profile.FromConfig(p.Config, p.Profile)
+	if err != nil {
+		return nil, err
+	}
+	// the CLI caches in the same config's cache directory, and an unreadable
+	// cache only costs a login
+	if dir, err := profile.ConfigCacheDir(p.Config); err == nil {
+		if cached, err := profile.LoadCachedCredentials(dir, prof.Name, p.ExpiryWindow); err == nil && cached != nil {
+			return cached.STS(), nil
+		}
+	}
+
+	s, err := Settings(p.Config, &prof)
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]Option{WithSettings(s), WithPassword(Password(p.Config, &prof))}, p.opts...)
+	return NewClient(opts...).Credentials(ctx, &prof)
+}
@@ -0,0 +1,39 @@
+// Package filelock takes the advisory locks cu-sts processes use to keep from
+// updating the same files at once.
+package filelock
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// Acquire opens the lock file at path, creating it if needed, and waits up
+// to timeout for an exclusive lock on it. Release must be called to unlock
+// it.
+func Acquire(path string, timeout time.Duration) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if err = lock(f); err == nil {
+			return f, nil
+		}
+		if err != ErrLocked || time.Now().After(deadline) {
+			f.Close()
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Release unlocks and closes a lock file returned by Acquire.
+func Release(f *os.File) error {
+	unlock(f)
+	return f.Close()
+}
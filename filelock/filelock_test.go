@@ -0,0 +1,46 @@
+package filelock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cu-sts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log.lock")
+
+	held, err := Acquire(path, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	// a second lock waits for the first and gives up after the timeout
+	if f, err := Acquire(path, 200*time.Millisecond); err != ErrLocked {
+		if f != nil {
+			Release(f)
+		}
+		t.Fatalf("Acquire() while locked error = %v, want %v", err, ErrLocked)
+	}
+
+	done := make(chan error)
+	go func() {
+		f, err := Acquire(path, 5*time.Second)
+		if err == nil {
+			err = Release(f)
+		}
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if err := Release(held); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Acquire() after Release error = %v", err)
+	}
+}
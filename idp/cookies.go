@@ -0,0 +1,298 @@
+package idp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// storedCookie is a cookie as kept in the encrypted cookie file.
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Secure   bool      `json:"secure"`
+	HTTPOnly bool      `json:"http_only"`
+	// HostOnly cookies are only sent to Domain itself, not its subdomains.
+	HostOnly bool `json:"host_only"`
+}
+
+func (c *storedCookie) expired() bool {
+	return !c.Expires.IsZero() && c.Expires.Before(time.Now())
+}
+
+// matches reports whether the cookie should be sent with a request to u.
+func (c *storedCookie) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	domain := strings.TrimPrefix(c.Domain, ".")
+	if host != domain && (c.HostOnly || !strings.HasSuffix(host, "."+domain)) {
+		return false
+	}
+	if c.Secure && u.Scheme != "https" {
+		return false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.HasPrefix(path, c.Path)
+}
+
+// cookieJar is an http.CookieJar that, unlike net/http/cookiejar, can list its
+// cookies so they can be saved between runs.
+type cookieJar struct {
+	mu      sync.Mutex
+	cookies []storedCookie
+}
+
+// SetCookies implements http.CookieJar.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range cookies {
+		sc := storedCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+		}
+		if sc.Domain == "" {
+			sc.Domain = strings.ToLower(u.Hostname())
+			sc.HostOnly = true
+		}
+		if sc.Path == "" || sc.Path[0] != '/' {
+			sc.Path = "/"
+		}
+		switch {
+		case c.MaxAge < 0:
+			sc.Expires = time.Unix(1, 0)
+		case c.MaxAge > 0:
+			sc.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			sc.Expires = c.Expires
+		}
+		j.set(sc)
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var cookies []*http.Cookie
+	for _, c := range j.cookies {
+		if !c.expired() && c.matches(u) {
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// set replaces the cookie with the same name, domain and path, dropping it
+// if it has expired.
+func (j *cookieJar) set(sc storedCookie) {
+	kept := j.cookies[:0]
+	for _, c := range j.cookies {
+		if c.Name != sc.Name || c.Domain != sc.Domain || c.Path != sc.Path {
+			kept = append(kept, c)
+		}
+	}
+	j.cookies = kept
+	if !sc.expired() {
+		j.cookies = append(j.cookies, sc)
+	}
+}
+
+// addChromeCookies copies all of the Chrome instance's cookies into the jar.
+func (j *cookieJar) addChromeCookies(c *Chrome) error {
+	var cookies []*network.Cookie
+	err := c.C.Run(c.Ctxt, chromedp.ActionFunc(func(ctxt context.Context, h cdp.Executor) error {
+		var err error
+		cookies, err = network.GetAllCookies().Do(ctxt, h)
+		return err
+	}))
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		sc := storedCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   strings.TrimPrefix(c.Domain, "."),
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			HostOnly: !strings.HasPrefix(c.Domain, "."),
+		}
+		// session cookies are exactly what we want to keep
+		if !c.Session && c.Expires > 0 {
+			sc.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		j.set(sc)
+	}
+	return nil
+}
+
+// A cookieStore saves a cookieJar to disk encrypted with AES-GCM. The key is
+// kept in a separate user-only file, so the encryption only protects copies of
+// the cookie file made without the key, such as backups. The username is
+// bound to the ciphertext so one NetID's cookies are never used for another.
+type cookieStore struct {
+	dir      string
+	username string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func (s *cookieStore) path() string {
+	return filepath.Join(s.dir, "cookies-"+unsafeFileChars.ReplaceAllString(s.username, "_")+".enc")
+}
+
+// Load returns the stored jar, or an empty one if nothing has been stored.
+func (s *cookieStore) Load() (*cookieJar, error) {
+	jar := &cookieJar{}
+
+	data, err := ioutil.ReadFile(s.path())
+	if os.IsNotExist(err) {
+		return jar, nil
+	}
+	if err != nil {
+		return jar, err
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return jar, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return jar, errors.New("cookie file is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(s.username))
+	if err != nil {
+		return jar, fmt.Errorf("unable to decrypt cookie file: %v", err)
+	}
+	if err = json.Unmarshal(plain, &jar.cookies); err != nil {
+		return jar, fmt.Errorf("unable to decode cookie file: %v", err)
+	}
+	return jar, nil
+}
+
+// Save encrypts and writes the jar's unexpired cookies.
+func (s *cookieStore) Save(jar *cookieJar) error {
+	jar.mu.Lock()
+	var cookies []storedCookie
+	for _, c := range jar.cookies {
+		if !c.expired() {
+			cookies = append(cookies, c)
+		}
+	}
+	jar.mu.Unlock()
+
+	plain, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+	if err = userOnlyDir(s.dir); err != nil {
+		return err
+	}
+	gcm, err := s.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(), gcm.Seal(nonce, nonce, plain, []byte(s.username)), 0600)
+}
+
+// cipher returns an AES-256-GCM cipher using the key file, creating it if needed.
+func (s *cookieStore) cipher() (cipher.AEAD, error) {
+	keyFile := filepath.Join(s.dir, "cookies.key")
+
+	key, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key, err = createKey(keyFile)
+	}
+	if err == nil && len(key) != 32 {
+		err = fmt.Errorf("key is %d bytes, not 32", len(key))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to use cookie key %s: %v", keyFile, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// createKey writes a new random key to a temporary file and links it to path,
+// which fails if path exists. Concurrent logins therefore agree on a single,
+// complete key: whoever loses the race reads the winner's.
+func createKey(path string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(key)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Link(tmp.Name(), path); os.IsExist(err) {
+		return ioutil.ReadFile(path)
+	}
+	return key, err
+}
+
+// ForgetSessions deletes the IdP session cookies stored in dir for every
+// username, and the key they were encrypted with.
+func ForgetSessions(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "cookies-*.enc"))
+	if err != nil {
+		return err
+	}
+	for _, f := range append(files, filepath.Join(dir, "cookies.key")) {
+		if err = os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
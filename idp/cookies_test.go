@@ -0,0 +1,83 @@
+package idp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCookieStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cu-sts-cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jar := &cookieJar{}
+	jar.set(storedCookie{Name: "shib", Value: "session", Domain: "shibidp.cit.cornell.edu", Path: "/"})
+	jar.set(storedCookie{Name: "old", Value: "x", Domain: "shibidp.cit.cornell.edu", Path: "/", Expires: time.Now().Add(-time.Hour)})
+
+	store := &cookieStore{dir: filepath.Join(dir, "cache"), username: "abc1"}
+	if err = store.Save(jar); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := ioutil.ReadFile(store.path())
+	if bytes.Contains(data, []byte("session")) {
+		t.Error("cookie file contains the cookie value in plaintext")
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	u, _ := url.Parse("https://shibidp.cit.cornell.edu/idp/profile")
+	if cookies := loaded.Cookies(u); len(cookies) != 1 || cookies[0].Value != "session" {
+		t.Errorf("Load() cookies = %v, want only shib=session", cookies)
+	}
+
+	// the username is bound to the ciphertext
+	other := &cookieStore{dir: store.dir, username: "xyz2"}
+	if err = ioutil.WriteFile(other.path(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Load(); err == nil {
+		t.Error("Load() of another NetID's cookie file succeeded")
+	}
+}
+
+func TestCookieKeyConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cu-sts-cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := make([][]byte, 10)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store := &cookieStore{dir: dir, username: "abc1"}
+			if _, err := store.cipher(); err != nil {
+				t.Error(err)
+			}
+			keys[i], _ = ioutil.ReadFile(filepath.Join(dir, "cookies.key"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if len(key) != 32 || !bytes.Equal(key, keys[0]) {
+			t.Fatalf("key %d = %x, want the same 32 byte key as %x", i, key, keys[0])
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("files = %v, want only cookies.key", files)
+	}
+}
@@ -0,0 +1,207 @@
+package idp
+
+// cornellFlow logs in through Cornell's Shibboleth IdP and DUO iframe to
+// signin-sts.aws.cucloud.net. Variables: username, password, duo_method,
+// duo_label, duo_fallback, duo_fallback_label, duo_retries and duo_remember.
+//
+// With a persistent browser profile the password and DUO steps are skipped
+// when the IdP session or DUO's remembered device is still valid.
+const cornellFlow = `
+name = "cornell"
+
+[[steps]]
+action = "log"
+message = "Fetching IdP Shibboleth login page."
+
+[[steps]]
+action = "navigate"
+url = "https://signin-sts.aws.cucloud.net"
+error = "login_page"
+
+[[steps]]
+action = "wait"
+selector = "#netid, #saml_response"
+phase = "login"
+error = "login_page"
+
+[[steps]]
+action = "branch"
+selector = "#saml_response"
+goto = "sso"
+
+[[steps]]
+action = "log"
+message = "Submitting username & password."
+
+[[steps]]
+action = "fill"
+selector = "#netid"
+value = "{{.username}}"
+phase = "login"
+error = "login_page"
+
+[[steps]]
+action = "fill"
+selector = "#password"
+value = "{{.password}}"
+phase = "login"
+error = "login_page"
+
+[[steps]]
+action = "submit"
+selector = "#password"
+phase = "login"
+error = "login_page"
+
+[[steps]]
+action = "sleep"
+seconds = 1
+
+[[steps]]
+action = "branch"
+selector = "#reason"
+text = "Unable"
+error = "invalid_credentials"
+
+[[steps]]
+action = "log"
+message = "Submitting selected DUO method."
+
+# poll until the DUO buttons load, or a remembered device skips DUO. For
+# multi-device users the frame load might be "partial" w/ a checkbox available
+# before the buttons, so we wait for the buttons.
+[[steps]]
+name = "duo_wait"
+action = "branch"
+selector = "#saml_response"
+goto = "remembered"
+
+[[steps]]
+action = "branch"
+frame = "iframe#duo_iframe"
+selector = "//button[contains(., 'Push') or contains(., 'Call')]"
+goto = "duo_ready"
+
+[[steps]]
+action = "sleep"
+seconds = 1
+error = "duo_timeout"
+
+[[steps]]
+action = "goto"
+goto = "duo_wait"
+phase = "duo_frame"
+error = "duo_timeout"
+
+[[steps]]
+name = "duo_ready"
+action = "check"
+frame = "iframe#duo_iframe"
+selector = "//input[@name='dampen_choice']"
+when = "{{.duo_remember}}"
+phase = "duo"
+optional = true
+
+[[steps]]
+action = "branch"
+frame = "iframe#duo_iframe"
+selector = "//small[@class='used-automatically']"
+goto = "auto_selected"
+
+[[steps]]
+action = "click"
+frame = "iframe#duo_iframe"
+selector = "//button[contains(., '{{.duo_label}}')]"
+phase = "duo"
+error = "duo_timeout"
+
+[[steps]]
+action = "goto"
+goto = "waiting"
+
+[[steps]]
+name = "auto_selected"
+action = "log"
+level = "warn"
+message = "Auto-selected DUO method used, ignoring configured method '{{.duo_method}}'."
+
+[[steps]]
+name = "waiting"
+action = "log"
+message = "Waiting for DUO response and SAML assertion."
+event = "duo_request"
+
+[[steps]]
+name = "wait_saml"
+action = "branch"
+selector = "#saml_response"
+goto = "extract"
+
+[[steps]]
+action = "branch"
+frame = "iframe#duo_iframe"
+selector = "//*[contains(@class, 'message') and contains(., 'denied')]"
+error = "duo_denied"
+
+[[steps]]
+action = "branch"
+frame = "iframe#duo_iframe"
+selector = "//*[contains(@class, 'message') and contains(., 'timed out')]"
+goto = "resend"
+retries = "{{.duo_retries}}"
+error = "duo_timeout"
+
+[[steps]]
+action = "sleep"
+seconds = 1
+error = "saml_timeout"
+
+[[steps]]
+action = "goto"
+goto = "wait_saml"
+
+[[steps]]
+name = "resend"
+action = "log"
+message = "DUO request timed out, re-sending via {{.duo_fallback}}."
+event = "duo_resend"
+
+[[steps]]
+action = "click"
+frame = "iframe#duo_iframe"
+selector = "//button[contains(., '{{.duo_fallback_label}}')]"
+phase = "duo"
+error = "duo_timeout"
+
+# give DUO a moment to replace the timed out message
+[[steps]]
+action = "sleep"
+seconds = 2
+error = "saml_timeout"
+
+[[steps]]
+action = "goto"
+goto = "wait_saml"
+
+[[steps]]
+name = "sso"
+action = "log"
+message = "IdP session still valid, skipping username & password."
+
+[[steps]]
+action = "goto"
+goto = "extract"
+
+[[steps]]
+name = "remembered"
+action = "log"
+message = "DUO remembered this device, skipping DUO."
+
+[[steps]]
+name = "extract"
+action = "extract"
+selector = "#saml_response"
+attribute = "value"
+variable = "saml_response"
+error = "saml_timeout"
+`
@@ -0,0 +1,32 @@
+package idp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by GetSAMLResponse. Failures caused by an underlying error
+// (e.g. a chromedp timeout) wrap it, so callers should compare with errors.Is.
+var (
+	// ErrPasswordRequired is returned when no password was given or entered.
+	ErrPasswordRequired = errors.New("must enter a password")
+	// ErrPasswordCommand is returned when the password_command fails.
+	ErrPasswordCommand = errors.New("password_command failed")
+	// ErrChromeStart is returned when a Chrome instance could not be started.
+	ErrChromeStart = errors.New("unable to start a chrome instance")
+	// ErrLoginPage is returned when the IdP login page could not be loaded or used.
+	ErrLoginPage = errors.New("unable to use the IdP login page")
+	// ErrInvalidCredentials is returned when the IdP rejects the NetID and password.
+	ErrInvalidCredentials = errors.New("login failed, invalid credentials")
+	// ErrDuoTimeout is returned when the DUO frame doesn't load or the DUO request times out.
+	ErrDuoTimeout = errors.New("timeout waiting for DUO")
+	// ErrDuoDenied is returned when the DUO request is denied.
+	ErrDuoDenied = errors.New("DUO request was denied")
+	// ErrSAMLTimeout is returned when the SAML assertion never appears.
+	ErrSAMLTimeout = errors.New("timeout waiting for SAML assertion")
+)
+
+// wrap annotates err with one of the exported error values.
+func wrap(kind, err error) error {
+	return fmt.Errorf("%w: %v", kind, err)
+}
@@ -0,0 +1,223 @@
+package idp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// ErrFlow is returned when a login flow is invalid or fails in a step that
+// doesn't name a more specific error.
+var ErrFlow = errors.New("login flow failed")
+
+// A Flow is an ordered list of Steps that logs in to an IdP and extracts the
+// base-64 SAMLResponse into the "saml_response" variable.
+type Flow struct {
+	Name  string `mapstructure:"name"`
+	Steps []Step `mapstructure:"steps"`
+}
+
+// A Step is a single action in a Flow. Every string field except Action, Name,
+// Goto and Error is a text/template rendered with the flow's variables, e.g.
+// value = "{{.username}}".
+//
+// Selectors starting with "/" or "(" are XPath, anything else is a CSS
+// selector. If Frame is set, Selector and Text are matched inside the document
+// of the iframe it selects.
+type Step struct {
+	// Name labels the step so branch and goto steps can jump to it.
+	Name string `mapstructure:"name"`
+	// Action is one of navigate, fill, click, check, submit, wait, branch,
+	// goto, extract, sleep or log.
+	Action string `mapstructure:"action"`
+	// When, if set, skips the step unless it renders to something other
+	// than "" or "false", e.g. when = "{{.duo_remember}}".
+	When string `mapstructure:"when"`
+
+	URL       string `mapstructure:"url"`
+	Selector  string `mapstructure:"selector"`
+	Frame     string `mapstructure:"frame"`
+	Text      string `mapstructure:"text"`
+	Value     string `mapstructure:"value"`
+	Attribute string `mapstructure:"attribute"`
+	Variable  string `mapstructure:"variable"`
+	Message   string `mapstructure:"message"`
+	Level     string `mapstructure:"level"`
+	Seconds   int    `mapstructure:"seconds"`
+
+	// Goto is the step a branch (when its condition matches) or goto jumps to.
+	Goto string `mapstructure:"goto"`
+	// Retries limits how often a branch jumps to Goto, after which it fails
+	// with Error. Empty means unlimited.
+	Retries string `mapstructure:"retries"`
+	// Error names the error returned when the step fails, or when a branch
+	// matches without a Goto: login_page, invalid_credentials, duo_timeout,
+	// duo_denied or saml_timeout.
+	Error string `mapstructure:"error"`
+	// Phase selects the Settings timeout for the step: login, duo or
+	// duo_frame. Empty means only the overall Timeout applies. A branch or
+	// goto keeps jumping to Goto until the timeout has passed since its first
+	// jump, then fails with Error like it does after its Retries.
+	Phase string `mapstructure:"phase"`
+	// Optional steps continue when they fail, e.g. a wait times out.
+	Optional bool `mapstructure:"optional"`
+	// Event, if set, is reported to the Settings' OnMFA callback after the
+	// step runs: duo_request or duo_resend.
+	Event string `mapstructure:"event"`
+}
+
+// flowErrors maps a Step's Error to the exported error values.
+var flowErrors = map[string]error{
+	"login_page":          ErrLoginPage,
+	"invalid_credentials": ErrInvalidCredentials,
+	"duo_timeout":         ErrDuoTimeout,
+	"duo_denied":          ErrDuoDenied,
+	"saml_timeout":        ErrSAMLTimeout,
+}
+
+var flowActions = map[string]bool{
+	"navigate": true,
+	"fill":     true,
+	"click":    true,
+	"check":    true,
+	"submit":   true,
+	"wait":     true,
+	"branch":   true,
+	"goto":     true,
+	"extract":  true,
+	"sleep":    true,
+	"log":      true,
+}
+
+// DefaultFlow returns the built-in Cornell Shibboleth + DUO login flow.
+func DefaultFlow() Flow {
+	f, err := ParseFlow(strings.NewReader(cornellFlow), "toml")
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in login flow: %v", err))
+	}
+	return f
+}
+
+// LoadFlow reads a Flow from a TOML or YAML file, chosen by its extension.
+func LoadFlow(path string) (Flow, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return Flow{}, fmt.Errorf("%w: unable to read %s: %v", ErrFlow, path, err)
+	}
+	return decodeFlow(v)
+}
+
+// ParseFlow reads a Flow of the given config type ("toml" or "yaml") from r.
+func ParseFlow(r io.Reader, configType string) (Flow, error) {
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(r); err != nil {
+		return Flow{}, fmt.Errorf("%w: %v", ErrFlow, err)
+	}
+	return decodeFlow(v)
+}
+
+func decodeFlow(v *viper.Viper) (Flow, error) {
+	var f Flow
+	if err := v.Unmarshal(&f); err != nil {
+		return f, fmt.Errorf("%w: unable to decode flow: %v", ErrFlow, err)
+	}
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// Validate checks every step's action, jump target, error, event and phase.
+func (f *Flow) Validate() error {
+	if len(f.Steps) == 0 {
+		return fmt.Errorf("%w: flow %s has no steps", ErrFlow, f.Name)
+	}
+
+	for i, step := range f.Steps {
+		if !flowActions[step.Action] {
+			return fmt.Errorf("%w: step %d has unknown action %q", ErrFlow, i+1, step.Action)
+		}
+		if step.Goto != "" && f.index(step.Goto) < 0 {
+			return fmt.Errorf("%w: step %d jumps to unknown step %q", ErrFlow, i+1, step.Goto)
+		}
+		if step.Action == "goto" && step.Goto == "" {
+			return fmt.Errorf("%w: goto step %d has no target", ErrFlow, i+1)
+		}
+		if step.Action == "branch" && step.Goto == "" && step.Error == "" {
+			return fmt.Errorf("%w: branch step %d needs a goto or an error", ErrFlow, i+1)
+		}
+		if _, ok := flowErrors[step.Error]; step.Error != "" && !ok {
+			return fmt.Errorf("%w: step %d has unknown error %q", ErrFlow, i+1, step.Error)
+		}
+		switch step.Event {
+		case "", MFARequest, MFAResend:
+		default:
+			return fmt.Errorf("%w: step %d has unknown event %q", ErrFlow, i+1, step.Event)
+		}
+		switch step.Phase {
+		case "", "login", "duo", "duo_frame":
+		default:
+			return fmt.Errorf("%w: step %d has unknown phase %q", ErrFlow, i+1, step.Phase)
+		}
+	}
+	return nil
+}
+
+// index returns the position of the step named name, or -1.
+func (f *Flow) index(name string) int {
+	for i, step := range f.Steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// err returns the error for a failed step, wrapping cause if set.
+func (step *Step) err(cause error) error {
+	kind, ok := flowErrors[step.Error]
+	if !ok {
+		kind = ErrFlow
+	}
+	if cause == nil {
+		return kind
+	}
+	return wrap(kind, cause)
+}
+
+// retries renders and parses the step's Retries, returning -1 for unlimited.
+func (step *Step) retries(vars map[string]string) (int, error) {
+	if step.Retries == "" {
+		return -1, nil
+	}
+	s, err := render(step.Retries, vars)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// render executes a step field as a template with the flow's variables.
+func render(text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	t, err := template.New("step").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFlow, err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFlow, err)
+	}
+	return buf.String(), nil
+}
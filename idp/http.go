@@ -0,0 +1,452 @@
+package idp
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cu-sts/logging"
+)
+
+// httpBackend runs a flow over plain HTTP on the static HTML of the last page
+// it loaded. It follows the SAML HTTP-POST binding forms a browser would
+// auto-submit, but can't run scripts, look into iframes or use XPath, so
+// flows that need them, like Cornell's DUO steps, need Chrome. A silent
+// backend never prompts and fails with errNoSession once the flow needs the
+// password.
+type httpBackend struct {
+	ctxt   context.Context
+	client *http.Client
+	silent bool
+
+	url      *url.URL
+	elements []element
+	// filled are the values fill and check steps set, sent when their form
+	// is submitted.
+	filled url.Values
+}
+
+// An element is an HTML start tag on the page.
+type element struct {
+	tag   string
+	attrs map[string]string
+	// text is the markup up to the tag's first closing tag.
+	text string
+	// form is the index of the form element the element is in, or -1.
+	form int
+}
+
+var (
+	tagPattern  = regexp.MustCompile(`(?is)<(/?)([a-z][a-z0-9-]*)\b([^>]*)>`)
+	attrPattern = regexp.MustCompile(`(?is)([a-z_:][a-z0-9_:.-]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+)
+
+// voidTags have no content or closing tag.
+var voidTags = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+func newHTTPBackend(ctxt context.Context, jar http.CookieJar, silent bool) *httpBackend {
+	return &httpBackend{
+		ctxt:   ctxt,
+		client: &http.Client{Jar: jar},
+		silent: silent,
+		filled: url.Values{},
+	}
+}
+
+func (h *httpBackend) name() string {
+	return "http"
+}
+
+func (h *httpBackend) loginContext() context.Context {
+	return h.ctxt
+}
+
+func (h *httpBackend) password(s Settings, username string) (string, error) {
+	if h.silent {
+		return "", errNoSession
+	}
+	return getPassword(h.ctxt, s, username)
+}
+
+// logStep prints the message, only for --debug when trying a silent SSO.
+func (h *httpBackend) logStep(step *Step) {
+	if h.silent {
+		logging.Debugf("(http) %s", step.Message)
+		return
+	}
+	printStep(h, step)
+}
+
+func (h *httpBackend) withSnapshot(err error, s Settings, vars map[string]string) error {
+	return err
+}
+
+// runStep performs a single step on the current page, returning true if a
+// branch matched.
+func (h *httpBackend) runStep(step *Step, s Settings, vars map[string]string) (bool, error) {
+	ctxt, cancel := phaseContext(h.ctxt, step.Phase, s)
+	defer cancel()
+
+	if step.Frame != "" {
+		return false, fmt.Errorf("%w: %s step in frame %s needs Chrome", ErrFlow, step.Action, step.Frame)
+	}
+	if step.Action == "navigate" {
+		if err := h.load(ctxt, "GET", step.URL, nil); err != nil {
+			return false, step.err(err)
+		}
+		return false, nil
+	}
+
+	i, err := h.find(step.Selector, step.Text)
+	if err != nil {
+		return false, err
+	}
+	if step.Action == "branch" {
+		return i >= 0, nil
+	}
+	if i < 0 {
+		return false, step.err(fmt.Errorf("no element matches %s", step.Selector))
+	}
+	el := h.elements[i]
+
+	switch step.Action {
+	case "wait":
+		// the page is static, so the element is either there or never will be
+
+	case "fill", "check":
+		name := el.attrs["name"]
+		if name == "" {
+			return false, step.err(fmt.Errorf("%s has no name to submit", step.Selector))
+		}
+		value := step.Value
+		if step.Action == "check" {
+			if value = el.attrs["value"]; value == "" {
+				value = "on"
+			}
+		}
+		h.filled.Set(name, value)
+
+	case "submit":
+		form := el.form
+		if el.tag == "form" {
+			form = i
+		}
+		if err = h.submit(ctxt, form, nil); err != nil {
+			return false, step.err(err)
+		}
+
+	case "click":
+		typ := strings.ToLower(el.attrs["type"])
+		switch {
+		case el.tag == "a" && el.attrs["href"] != "":
+			err = h.load(ctxt, "GET", el.attrs["href"], nil)
+		case el.tag == "button" && typ != "button" && typ != "reset",
+			el.tag == "input" && (typ == "submit" || typ == "image"):
+			err = h.submit(ctxt, el.form, &el)
+		default:
+			return false, fmt.Errorf("%w: clicking %s needs Chrome", ErrFlow, step.Selector)
+		}
+		if err != nil {
+			return false, step.err(err)
+		}
+
+	case "extract":
+		value, ok := el.attrs[strings.ToLower(step.Attribute)]
+		if !ok {
+			return false, step.err(fmt.Errorf("no %s attribute on %s", step.Attribute, step.Selector))
+		}
+		vars[step.Variable] = value
+	}
+	return false, nil
+}
+
+// load requests u, relative to the current page, and replaces the page with
+// the response. It follows SAML forms a browser would auto-submit.
+func (h *httpBackend) load(ctxt context.Context, method, u string, values url.Values) error {
+	for hops := 0; hops < 5; hops++ {
+		target, err := h.resolve(u)
+		if err != nil {
+			return err
+		}
+		var req *http.Request
+		if method == "POST" {
+			req, err = http.NewRequest("POST", target.String(), strings.NewReader(values.Encode()))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		} else {
+			if values != nil {
+				target.RawQuery = values.Encode()
+			}
+			req, err = http.NewRequest("GET", target.String(), nil)
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := h.client.Do(req.WithContext(ctxt))
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s returned %s", target, resp.Status)
+		}
+
+		h.url = resp.Request.URL
+		h.elements = parsePage(string(body))
+		h.filled = url.Values{}
+
+		form := h.samlForm()
+		if form < 0 {
+			return nil
+		}
+		logging.Debugf("(http) Submitting SAML form.")
+		if method, u, values, err = h.formRequest(form, nil); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("too many SAML forms")
+}
+
+// resolve returns u relative to the current page.
+func (h *httpBackend) resolve(u string) (*url.URL, error) {
+	ref, err := url.Parse(u)
+	if err != nil || h.url == nil {
+		return ref, err
+	}
+	return h.url.ResolveReference(ref), nil
+}
+
+// samlForm returns the index of a form posting a SAMLResponse or SAMLRequest,
+// or -1. signin-sts' page with the assertion in #saml_response is a result,
+// not a form to submit.
+func (h *httpBackend) samlForm() int {
+	for _, el := range h.elements {
+		if el.attrs["id"] == "saml_response" {
+			return -1
+		}
+	}
+	for _, el := range h.elements {
+		if el.tag == "input" && el.form >= 0 && (el.attrs["name"] == "SAMLResponse" || el.attrs["name"] == "SAMLRequest") {
+			return el.form
+		}
+	}
+	return -1
+}
+
+// submit sends the form with its fields, the filled values and, if set, the
+// name and value of the button that submitted it.
+func (h *httpBackend) submit(ctxt context.Context, form int, button *element) error {
+	method, action, values, err := h.formRequest(form, button)
+	if err != nil {
+		return err
+	}
+	return h.load(ctxt, method, action, values)
+}
+
+// formRequest returns the method, action and values that submit the form.
+func (h *httpBackend) formRequest(form int, button *element) (string, string, url.Values, error) {
+	if form < 0 || form >= len(h.elements) || h.elements[form].tag != "form" {
+		return "", "", nil, fmt.Errorf("element isn't in a form")
+	}
+	values := url.Values{}
+	for _, el := range h.elements {
+		name := el.attrs["name"]
+		if el.form != form || name == "" {
+			continue
+		}
+		if filled, ok := h.filled[name]; ok {
+			values[name] = filled
+			continue
+		}
+		switch typ := strings.ToLower(el.attrs["type"]); {
+		case el.tag == "input" && (typ == "checkbox" || typ == "radio"):
+			if _, checked := el.attrs["checked"]; checked {
+				value := el.attrs["value"]
+				if value == "" {
+					value = "on"
+				}
+				values.Add(name, value)
+			}
+		case el.tag == "input" && (typ == "submit" || typ == "image" || typ == "button" || typ == "reset"):
+		case el.tag == "input":
+			values.Add(name, el.attrs["value"])
+		case el.tag == "textarea":
+			values.Add(name, html.UnescapeString(el.text))
+		}
+	}
+	if button != nil && button.attrs["name"] != "" {
+		values.Set(button.attrs["name"], button.attrs["value"])
+	}
+
+	f := h.elements[form].attrs
+	action := f["action"]
+	if action == "" && h.url != nil {
+		action = h.url.String()
+	}
+	method := strings.ToUpper(f["method"])
+	if method != "POST" {
+		method = "GET"
+	}
+	return method, action, values, nil
+}
+
+// find returns the index of the first element matching the CSS selector and,
+// if text is set, containing it, or -1. An empty selector matches the body.
+func (h *httpBackend) find(sel, text string) (int, error) {
+	if sel == "" {
+		sel = "body"
+	}
+	selectors, err := parseSelector(sel)
+	if err != nil {
+		return -1, err
+	}
+	for i, el := range h.elements {
+		if text != "" && !strings.Contains(el.text, text) {
+			continue
+		}
+		for _, s := range selectors {
+			if s.matches(el) {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+// parsePage returns the start tags of an HTML page, in order.
+func parsePage(page string) []element {
+	lower := strings.ToLower(page)
+	var elements []element
+	form := -1
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(page, -1) {
+		tag := strings.ToLower(page[m[4]:m[5]])
+		if m[3] > m[2] {
+			if tag == "form" {
+				form = -1
+			}
+			continue
+		}
+
+		el := element{tag: tag, attrs: parseAttrs(page[m[6]:m[7]]), form: form}
+		if !voidTags[tag] {
+			end := strings.Index(lower[m[1]:], "</"+tag)
+			if end < 0 {
+				end = len(page) - m[1]
+			}
+			el.text = page[m[1] : m[1]+end]
+		}
+		if tag == "form" {
+			el.form = -1
+			form = len(elements)
+		}
+		elements = append(elements, el)
+	}
+	return elements
+}
+
+// parseAttrs returns a tag's attributes by lower case name. Attributes without
+// a value, like checked, have an empty one.
+func parseAttrs(attrs string) map[string]string {
+	parsed := make(map[string]string)
+	for _, m := range attrPattern.FindAllStringSubmatch(attrs, -1) {
+		value := m[2]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			value = value[1 : len(value)-1]
+		}
+		name := strings.ToLower(m[1])
+		if _, ok := parsed[name]; !ok {
+			parsed[name] = html.UnescapeString(value)
+		}
+	}
+	return parsed
+}
+
+// A simpleSelector is a CSS compound selector: an optional tag name with #id,
+// .class and [attr] or [attr=value] conditions. Combinators aren't supported.
+type simpleSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrCondition
+}
+
+type attrCondition struct {
+	name, value string
+	hasValue    bool
+}
+
+var (
+	compoundPattern  = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:#[\w-]+|\.[\w-]+|\[[^\]]+\])*)$`)
+	conditionPattern = regexp.MustCompile(`#([\w-]+)|\.([\w-]+)|\[\s*([\w:-]+)\s*(?:=\s*("[^"]*"|'[^']*'|[^\]\s]+))?\s*\]`)
+)
+
+// parseSelector parses a comma-separated list of simpleSelectors.
+func parseSelector(sel string) ([]simpleSelector, error) {
+	if strings.HasPrefix(sel, "/") || strings.HasPrefix(sel, "(") {
+		return nil, fmt.Errorf("%w: XPath selector %s needs Chrome", ErrFlow, sel)
+	}
+	var selectors []simpleSelector
+	for _, part := range strings.Split(sel, ",") {
+		part = strings.TrimSpace(part)
+		m := compoundPattern.FindStringSubmatch(part)
+		if part == "" || m == nil {
+			return nil, fmt.Errorf("%w: selector %s needs Chrome, only tag, #id, .class and [attr=value] work over HTTP", ErrFlow, sel)
+		}
+		s := simpleSelector{tag: strings.ToLower(m[1])}
+		for _, c := range conditionPattern.FindAllStringSubmatch(m[2], -1) {
+			switch {
+			case c[1] != "":
+				s.id = c[1]
+			case c[2] != "":
+				s.classes = append(s.classes, c[2])
+			default:
+				value := c[4]
+				if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+					value = value[1 : len(value)-1]
+				}
+				s.attrs = append(s.attrs, attrCondition{strings.ToLower(c[3]), value, c[4] != ""})
+			}
+		}
+		selectors = append(selectors, s)
+	}
+	return selectors, nil
+}
+
+// matches reports whether el matches the selector.
+func (s simpleSelector) matches(el element) bool {
+	if s.tag != "" && s.tag != "*" && s.tag != el.tag {
+		return false
+	}
+	if s.id != "" && el.attrs["id"] != s.id {
+		return false
+	}
+	classes := make(map[string]bool)
+	for _, class := range strings.Fields(el.attrs["class"]) {
+		classes[class] = true
+	}
+	for _, class := range s.classes {
+		if !classes[class] {
+			return false
+		}
+	}
+	for _, a := range s.attrs {
+		value, ok := el.attrs[a.name]
+		if !ok || (a.hasValue && value != a.value) {
+			return false
+		}
+	}
+	return true
+}
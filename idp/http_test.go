@@ -0,0 +1,124 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	page := parsePage(`<html><body>
+<form id="login" action="/login" method="post">
+<input id="netid" name="j_username" class="text wide">
+<input type="checkbox" name="remember" checked>
+<button type="submit" name="_eventId_proceed">Login</button>
+</form>
+<p id="reason">Unable to log in</p>
+</body></html>`)
+
+	tests := []struct {
+		sel, text string
+		want      string
+		wantErr   bool
+	}{
+		{sel: "#netid", want: "input"},
+		{sel: "input#netid", want: "input"},
+		{sel: "#missing, #netid", want: "input"},
+		{sel: ".wide", want: "input"},
+		{sel: "input.text.wide[name=j_username]", want: "input"},
+		{sel: `[name="j_username"]`, want: "input"},
+		{sel: "input[checked]", want: "input"},
+		{sel: "button", text: "Login", want: "button"},
+		{sel: "#reason", text: "Unable", want: "p"},
+		{sel: "#reason", text: "expired"},
+		{sel: "input.narrow"},
+		{sel: "form input", wantErr: true},
+		{sel: "//input[@id='netid']", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sel+tt.text, func(t *testing.T) {
+			h := &httpBackend{elements: page}
+			i, err := h.find(tt.sel, tt.text)
+			if tt.wantErr {
+				if !errors.Is(err, ErrFlow) {
+					t.Errorf("find() error = %v, want %v", err, ErrFlow)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if i >= 0 {
+				got = page[i].tag
+			}
+			if got != tt.want {
+				t.Errorf("find() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPLogin(t *testing.T) {
+	var posted map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<form action="/login" method="post">
+<input type="hidden" name="csrf" value="token&amp;1">
+<input id="netid" name="j_username"><input id="password" name="j_password" type="password">
+<input type="checkbox" name="remember" value="yes">
+<button type="submit" name="_eventId_proceed" value="">Login</button>
+</form>`)
+		case "/login":
+			r.ParseForm()
+			posted = r.PostForm
+			fmt.Fprint(w, `<form action="/acs" method="post"><input type="hidden" name="SAMLResponse" value="x"></form>`)
+		case "/acs":
+			fmt.Fprint(w, `<input type="hidden" id="saml_response" value="assertion">`)
+		}
+	}))
+	defer server.Close()
+
+	f := Flow{Name: "test", Steps: []Step{
+		{Action: "navigate", URL: server.URL},
+		{Action: "fill", Selector: "#netid", Value: "{{.username}}"},
+		{Action: "fill", Selector: "#password", Value: "{{.password}}"},
+		{Action: "check", Selector: "[name=remember]"},
+		{Action: "click", Selector: "button"},
+		{Action: "extract", Selector: "#saml_response", Attribute: "value", Variable: "saml_response"},
+	}}
+	s := DefaultSettings()
+	s.Prompt = func(username string) (string, error) { return "secret", nil }
+	vars := map[string]string{"username": "abc1"}
+
+	got, err := httpLogin(context.Background(), f, nil, nil, vars, s)
+	if err != nil || got != "assertion" {
+		t.Fatalf("httpLogin() = %q, %v, want assertion", got, err)
+	}
+	want := map[string]string{"csrf": "token&1", "j_username": "abc1", "j_password": "secret", "remember": "yes", "_eventId_proceed": ""}
+	for name, value := range want {
+		if v, ok := posted[name]; !ok || v[0] != value {
+			t.Errorf("posted %s = %v, want %q", name, v, value)
+		}
+	}
+}
+
+func TestHTTPLoginNeedsChrome(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<iframe id="duo_iframe"></iframe>`)
+	}))
+	defer server.Close()
+
+	f := Flow{Name: "test", Steps: []Step{
+		{Action: "navigate", URL: server.URL},
+		{Action: "click", Frame: "iframe#duo_iframe", Selector: "button"},
+	}}
+	_, err := httpLogin(context.Background(), f, nil, nil, map[string]string{}, DefaultSettings())
+	if !errors.Is(err, ErrFlow) {
+		t.Errorf("httpLogin() error = %v, want %v", err, ErrFlow)
+	}
+}
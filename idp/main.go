@@ -5,12 +5,15 @@ package idp
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
 	"time"
 
+	"cu-sts/logging"
+
 	"github.com/chromedp/chromedp"
 	"github.com/chromedp/chromedp/runner"
 	"github.com/fatih/color"
@@ -22,102 +25,264 @@ type Chrome struct {
 	Ctxt   context.Context
 	C      *chromedp.CDP
 	Cancel context.CancelFunc
+
+	// stop kills Chrome, which runs on its own context so the page of a login
+	// that timed out or was cancelled can still be saved
+	stop context.CancelFunc
+}
+
+// promptMu keeps concurrent logins from prompting at the same time.
+var promptMu sync.Mutex
+
+// duoLabels are the DUO button labels for each method.
+var duoLabels = map[string]string{
+	"push": "Push",
+	"call": "Call",
 }
 
-var chrome Chrome
-var timeoutContext context.Context
+// GetSAMLResponse takes a NetID and Password and runs the Settings' login flow
+// to get the base-64 encoded SAMLResponse, by default from the final
+// signin-sts.aws.cucloud.net POST.
+func GetSAMLResponse(username, password string, s Settings, response *string) error {
+	var err error
+	*response, err = Login(context.Background(), username, password, s)
+	return err
+}
 
-// GetSAMLResponse takes a NetID and Password and gets the base-64 encoded
-// SAMLResponse from the final signin-sts.aws.cucloud.net POST.
-func GetSAMLResponse(username, password, duoMethod string, debug bool, response *string) error {
+// Login is GetSAMLResponse with a context. Cancelling ctxt stops the login and
+// shuts Chrome down. If password is empty it is asked for when needed, see
+// Settings.Prompt.
+func Login(ctxt context.Context, username, password string, s Settings) (string, error) {
 	var err error
+	logging.AddSecret(password)
 
-	if password == "" {
-		c := color.New(color.FgYellow)
-		c.Printf("Password: ")
-		passwordBytes, _ := gopass.GetPasswdMasked()
-		password = string(passwordBytes)
-	}
-
-	if password == "" {
-		return fmt.Errorf("ERROR: must enter a password.")
-	}
-
-	chrome.Ctxt, chrome.Cancel = context.WithTimeout(
-		context.Background(),
-		120*time.Second,
-	)
-	defer chrome.Cancel()
-
-	if debug {
-		chrome.C, err = chromedp.New(chrome.Ctxt,
-			chromedp.WithRunnerOptions(
-				runner.Flag("disable-web-security", true),
-				runner.Flag("headless", true),
-				runner.Flag("no-first-run", true),
-				runner.Flag("no-default-browser-check", true),
-			),
-			chromedp.WithLog(log.Printf),
-		)
-	} else {
-		chrome.C, err = chromedp.New(chrome.Ctxt,
-			chromedp.WithRunnerOptions(
-				runner.Flag("disable-web-security", true),
-				runner.Flag("headless", true),
-				runner.Flag("no-first-run", true),
-				runner.Flag("no-default-browser-check", true),
-			),
-		)
+	flow := s.Flow
+	if len(flow.Steps) == 0 {
+		flow = DefaultFlow()
+	}
+	visible := s.Browser == BrowserVisible
+	if visible {
+		if flow, err = visibleFlow(flow); err != nil {
+			return "", err
+		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("Unable to start a chrome instance: %s\n.", err)
+	fallback := s.DuoFallback
+	if fallback == "" {
+		fallback = s.DuoMethod
+	}
+	vars := map[string]string{
+		"username":           username,
+		"password":           password,
+		"duo_method":         s.DuoMethod,
+		"duo_label":          duoLabels[s.DuoMethod],
+		"duo_fallback":       fallback,
+		"duo_fallback_label": duoLabels[fallback],
+		"duo_retries":        strconv.Itoa(s.DuoRetries),
+		"duo_remember":       strconv.FormatBool(s.DuoRemember),
+		"duo_frame_timeout":  strconv.Itoa(int(s.DuoFrameTimeout.Seconds())),
+	}
+
+	// try the stored IdP session over plain HTTP before prompting or
+	// starting Chrome, unless the user wants to see the IdP pages
+	var store *cookieStore
+	var jar *cookieJar
+	if s.CookieDir != "" {
+		store = &cookieStore{dir: s.CookieDir, username: username}
+		if jar, err = store.Load(); err != nil {
+			logging.Warnf("Problem loading stored IdP session: %v", err)
+		}
+	}
+	if store != nil && !visible && s.Browser != BrowserHTTP {
+		response, err := silentSSO(ctxt, flow, jar, vars, s)
+		if err == nil {
+			logging.AddSecret(response)
+			if err = store.Save(jar); err != nil {
+				logging.Warnf("Problem saving IdP session: %v", err)
+			}
+			return response, nil
+		}
+		logging.Debugf("(http) Silent SSO failed, falling back to Chrome: %v", err)
+	}
+
+	if s.Browser == BrowserHTTP {
+		return httpLogin(ctxt, flow, store, jar, vars, s)
 	}
 
-	// register SIGINT handler to make sure we cleanup chrome
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT)
-	go func() {
-		for _ = range signalChan {
-			exitChromeQuietly()
-			os.Exit(1)
+	// With a persistent browser profile the IdP session may still be valid,
+	// so only prompt once the flow actually needs the password.
+	if password == "" && s.BrowserProfileDir == "" && !visible {
+		if vars["password"], err = getPassword(ctxt, s, username); err != nil {
+			return "", err
 		}
-	}()
+	}
 
+	c, err := startChrome(ctxt, s)
+	if err != nil {
+		return "", wrap(ErrChromeStart, err)
+	}
 	// ensure the chrome instance gets quietly killed on exit
 	// otherwise we can end up with an orphaned chrome-headless process
-	defer exitChromeQuietly()
+	defer c.exitQuietly()
 
-	fmt.Println("(chrome) Fetching IdP Shibboleth login page.")
-	if err = navToLogin(`https://signin-sts.aws.cucloud.net`); err != nil {
-		return err
+	if err = runFlow(c, flow, s, vars); err != nil {
+		return "", err
 	}
 
-	fmt.Println("(chrome) Submitting username & password.")
-	if err = submitCredentials(username, password); err != nil {
-		return err
+	if store != nil {
+		if err = jar.addChromeCookies(c); err == nil {
+			err = store.Save(jar)
+		}
+		if err != nil {
+			logging.Warnf("Problem saving IdP session: %v", err)
+		}
 	}
 
-	fmt.Println("(chrome) Submitting selected DUO method.")
-	if err = submitAuthMethod(duoMethod); err != nil {
-		return err
+	logging.AddSecret(vars["saml_response"])
+	return vars["saml_response"], nil
+}
+
+// httpLogin runs the flow over plain HTTP instead of in Chrome, prompting for
+// the password once a step needs it. The stored IdP session, if any, is used
+// and saved again.
+func httpLogin(ctxt context.Context, flow Flow, store *cookieStore, jar *cookieJar, vars map[string]string, s Settings) (string, error) {
+	ctxt, cancel := context.WithTimeout(ctxt, s.Timeout)
+	defer cancel()
+	if jar == nil {
+		jar = &cookieJar{}
+	}
+
+	if err := runFlow(newHTTPBackend(ctxt, jar, false), flow, s, vars); err != nil {
+		return "", err
 	}
+	if store != nil {
+		if err := store.Save(jar); err != nil {
+			logging.Warnf("Problem saving IdP session: %v", err)
+		}
+	}
+	logging.AddSecret(vars["saml_response"])
+	return vars["saml_response"], nil
+}
+
+// visibleFlow returns a flow that opens the first page of f and waits for the
+// user to finish the login by hand, however the IdP asks for it.
+func visibleFlow(f Flow) (Flow, error) {
+	for _, step := range f.Steps {
+		if step.Action != "navigate" {
+			continue
+		}
+		return Flow{
+			Name: f.Name + "-visible",
+			Steps: []Step{
+				{Action: "navigate", URL: step.URL, Error: "login_page"},
+				{Action: "log", Message: "Finish logging in in the Chrome window, waiting for the SAML assertion."},
+				{Action: "wait", Selector: "#saml_response", Error: "saml_timeout"},
+				{Action: "extract", Selector: "#saml_response", Attribute: "value", Variable: "saml_response", Error: "saml_timeout"},
+			},
+		}, nil
+	}
+	return f, fmt.Errorf("%w: flow %s never navigates", ErrFlow, f.Name)
+}
+
+// promptPassword reads username's password from the terminal. The prompt goes
+// to STDERR so STDOUT stays clean for command output.
+func promptPassword(username string) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	prompt := color.New(color.FgYellow).Sprintf("Password for %s: ", username)
+	passwordBytes, _ := gopass.GetPasswdPrompt(prompt, true, os.Stdin, os.Stderr)
+	if len(passwordBytes) == 0 {
+		return "", ErrPasswordRequired
+	}
+	logging.AddSecret(string(passwordBytes))
+	return string(passwordBytes), nil
+}
+
+// startChrome launches headless Chrome, using a persistent user-data directory
+// if one is configured. The context for tasks ends when ctxt is done or after
+// the Settings' Timeout, and Chrome keeps running until exitQuietly.
+func startChrome(ctxt context.Context, s Settings) (*Chrome, error) {
+	var err error
+
+	runnerOpts := []runner.CommandLineOption{
+		runner.Flag("disable-web-security", true),
+		runner.Flag("headless", s.Browser != BrowserVisible),
+		runner.Flag("no-first-run", true),
+		runner.Flag("no-default-browser-check", true),
+	}
+	if s.BrowserProfileDir != "" {
+		if err = userOnlyDir(s.BrowserProfileDir); err != nil {
+			return nil, err
+		}
+		runnerOpts = append(runnerOpts, runner.UserDataDir(s.BrowserProfileDir))
+	}
+
+	opts := []chromedp.Option{chromedp.WithRunnerOptions(runnerOpts...)}
+	if s.Debug {
+		opts = append(opts, chromedp.WithLog(chromeLog))
+	}
+
+	c := &Chrome{}
+	var browser context.Context
+	browser, c.stop = context.WithCancel(context.Background())
+	c.Ctxt, c.Cancel = context.WithTimeout(ctxt, s.Timeout)
+	if c.C, err = chromedp.New(browser, opts...); err != nil {
+		c.Cancel()
+		c.stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// keyEventParams matches the parameters of CDP messages typing keys, which
+// SendKeys sends one character of the password at a time.
+var keyEventParams = regexp.MustCompile(`("method":"Input\.(?:dispatchKeyEvent|insertText)","params":)\{[^}]*\}`)
 
-	fmt.Println("(chrome) Waiting for DUO response and SAML assertion.")
-	if err = getSAMLResponse(response); err != nil {
+// chromeLog is the chromedp debug logger. It hides typed keys, and the logging
+// package redacts the password, assertion and cookies from everything else.
+func chromeLog(msg string, args ...interface{}) {
+	msg = fmt.Sprintf(msg, args...)
+	logging.Debugf("%s", keyEventParams.ReplaceAllString(msg, `${1}"`+logging.Redacted+`"`))
+}
+
+// userOnlyDir creates dir if needed and makes sure only the user can access it,
+// since the browser profile holds the IdP session cookies.
+func userOnlyDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	return nil
+	return os.Chmod(dir, 0700)
 }
 
-func exitChromeQuietly() {
-	_ = chrome.C.Shutdown(chrome.Ctxt)
-	_ = chrome.C.Wait()
-	return
+// ForgetBrowserProfiles deletes the Chrome user-data directories in dir, the
+// per-identity browser profiles holding IdP sessions, returning how many it
+// deleted. Subdirectories Chrome didn't create are left alone.
+func ForgetBrowserProfiles(dir string) (int, error) {
+	// every user-data directory has a Local State file at its top, and a
+	// single identity uses dir itself
+	states, err := filepath.Glob(filepath.Join(dir, "*", "Local State"))
+	if err != nil {
+		return 0, err
+	}
+	if _, err = os.Stat(filepath.Join(dir, "Local State")); err == nil {
+		states = append(states, filepath.Join(dir, "Local State"))
+	}
+	for i, state := range states {
+		if err = os.RemoveAll(filepath.Dir(state)); err != nil {
+			return i, err
+		}
+	}
+	return len(states), nil
 }
 
-func navToLogin(url string) error {
-	return chrome.C.Run(chrome.Ctxt, chromedp.Tasks{
-		chromedp.Navigate(url),
-	})
+// exitQuietly shuts Chrome down. It uses its own context since the login's
+// may be what was cancelled.
+func (c *Chrome) exitQuietly() {
+	ctxt, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = c.C.Shutdown(ctxt)
+	_ = c.C.Wait()
+	c.Cancel()
+	c.stop()
 }
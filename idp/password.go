@@ -0,0 +1,148 @@
+package idp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"cu-sts/logging"
+)
+
+// getPassword runs the Settings' PasswordCommand or Prompt, or prompts on the
+// terminal for username's password if there is neither. The PasswordCommand
+// is killed when ctxt is done.
+func getPassword(ctxt context.Context, s Settings, username string) (string, error) {
+	switch {
+	case s.PasswordCommand != "":
+		return runPasswordCommand(ctxt, s, username)
+	case s.Prompt != nil:
+		password, err := s.Prompt(username)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrPasswordRequired, err)
+		}
+		if password == "" {
+			return "", ErrPasswordRequired
+		}
+		logging.AddSecret(password)
+		return password, nil
+	}
+	return promptPassword(username)
+}
+
+// runPasswordCommand returns the first line the PasswordCommand prints on
+// STDOUT. Its STDIN and STDERR are the terminal's so it can ask for a
+// passphrase itself, and CUSTS_USERNAME is set to username so one command can
+// serve several NetIDs.
+func runPasswordCommand(login context.Context, s Settings, username string) (string, error) {
+	ctxt, cancel := context.WithTimeout(login, s.PasswordCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if s.PasswordCommandShell {
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctxt, "cmd", "/C", s.PasswordCommand)
+		} else {
+			cmd = exec.CommandContext(ctxt, "/bin/sh", "-c", s.PasswordCommand)
+		}
+	} else {
+		args, err := SplitArgs(s.PasswordCommand)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrPasswordCommand, err)
+		}
+		cmd = exec.CommandContext(ctxt, args[0], args[1:]...)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Env = append(os.Environ(), "CUSTS_USERNAME="+username)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	logging.Debugf("Running password_command.")
+	err := cmd.Run()
+	if err := contextErr(login, s); err != nil {
+		return "", err
+	}
+	if ctxt.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%w: timed out after %v", ErrPasswordCommand, s.PasswordCommandTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("%w: exited with status %d", ErrPasswordCommand, exitErr.ExitCode())
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPasswordCommand, err)
+	}
+
+	password, _ := bufio.NewReader(&stdout).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("%w: printed no password", ErrPasswordCommand)
+	}
+	logging.AddSecret(password)
+	return password, nil
+}
+
+// SplitArgs splits a command line into words on spaces, honoring single and
+// double quotes and backslash escapes like a POSIX shell, but without any
+// expansion.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\' && quote != '\'':
+			if i+1 == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
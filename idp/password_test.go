@@ -0,0 +1,56 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctxt    context.Context
+		command string
+		want    string
+		wantErr error
+	}{
+		{"first line", context.Background(), "printf 'secret\\nmore\\n'", "secret", nil},
+		{"username", context.Background(), `echo "pw-$CUSTS_USERNAME"`, "pw-netid", nil},
+		{"no output", context.Background(), "true", "", ErrPasswordCommand},
+		{"fails", context.Background(), "exit 3", "", ErrPasswordCommand},
+		{"timed out", context.Background(), "exec sleep 5", "", ErrPasswordCommand},
+		{"login cancelled", cancelled, "exec sleep 5", "", context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.PasswordCommand = tt.command
+			s.PasswordCommandShell = true
+			s.PasswordCommandTimeout = 200 * time.Millisecond
+
+			start := time.Now()
+			got, err := runPasswordCommand(tt.ctxt, s, "netid")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("runPasswordCommand() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("runPasswordCommand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("runPasswordCommand() = %q, want %q", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("runPasswordCommand() took %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cu-sts/logging"
+
+	"github.com/chromedp/chromedp"
+)
+
+// A backend performs the page actions of a flow's steps, in Chrome or over
+// plain HTTP.
+type backend interface {
+	// name labels the backend's log messages, e.g. "chrome".
+	name() string
+	// loginContext is the context the whole login runs in.
+	loginContext() context.Context
+	// password returns the password the first time a step uses it.
+	password(s Settings, username string) (string, error)
+	// logStep prints a log step's message.
+	logStep(step *Step)
+	// runStep performs a single page action, returning true if a branch
+	// matched.
+	runStep(step *Step, s Settings, vars map[string]string) (bool, error)
+	// withSnapshot annotates the error of a failed step, e.g. with a snapshot
+	// of the page.
+	withSnapshot(err error, s Settings, vars map[string]string) error
+}
+
+// runFlow executes f's steps with b. Extract steps store their results in
+// vars. When a step fails, the backend may save a snapshot of the page to the
+// Settings' DebugDir.
+func runFlow(b backend, f Flow, s Settings, vars map[string]string) error {
+	jumps := make(map[int]int)
+	// looping is when each goto or branch first jumped, for its phase timeout
+	looping := make(map[int]time.Time)
+
+	for i := 0; i < len(f.Steps); {
+		if vars["password"] == "" && f.Steps[i].uses("password") {
+			password, err := b.password(s, vars["username"])
+			if err != nil {
+				return err
+			}
+			vars["password"] = password
+		}
+
+		step, err := f.Steps[i].rendered(vars)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if err = contextErr(b.loginContext(), s); err != nil {
+			return b.withSnapshot(err, s, vars)
+		}
+		if f.Steps[i].When != "" && (step.When == "" || step.When == "false") {
+			i++
+			continue
+		}
+
+		var jump bool
+		switch step.Action {
+		case "log":
+			b.logStep(&step)
+		case "goto":
+			jump = true
+		case "sleep":
+			select {
+			case <-b.loginContext().Done():
+			case <-time.After(time.Duration(step.Seconds) * time.Second):
+			}
+		default:
+			jump, err = b.runStep(&step, s, vars)
+		}
+		if err != nil && step.Optional {
+			logging.Debugf("(%s) Skipping optional step %d: %v", b.name(), i+1, err)
+			i++
+			continue
+		}
+		if cerr := contextErr(b.loginContext(), s); cerr != nil {
+			err = cerr
+		}
+		if err != nil {
+			return b.withSnapshot(err, s, vars)
+		}
+		if step.Event != "" && s.OnMFA != nil {
+			s.OnMFA(MFAEvent{
+				Username: vars["username"],
+				Method:   vars["duo_method"],
+				Event:    step.Event,
+				Message:  step.Message,
+			})
+		}
+		if jump && step.Goto != "" {
+			limit, err := step.retries(vars)
+			if err != nil {
+				return fmt.Errorf("%w: step %d: invalid retries: %v", ErrFlow, i+1, err)
+			}
+			if jumps[i] == 0 {
+				looping[i] = time.Now()
+			}
+			timeout, ok := phaseTimeout(step.Phase, s)
+			expired := ok && time.Since(looping[i]) >= timeout
+			if (limit >= 0 && jumps[i] >= limit) || expired {
+				if step.Error != "" {
+					return b.withSnapshot(step.err(nil), s, vars)
+				}
+				i++
+				continue
+			}
+			jumps[i]++
+			i = f.index(step.Goto)
+			continue
+		}
+		if jump && step.Action == "branch" {
+			return b.withSnapshot(step.err(nil), s, vars)
+		}
+		i++
+	}
+
+	if vars["saml_response"] == "" {
+		return fmt.Errorf("%w: flow %s finished without a saml_response", ErrFlow, f.Name)
+	}
+	return nil
+}
+
+// contextErr returns the error for the login's context being done, or nil. It
+// doesn't depend on the step that was running, whose Error may be what a
+// branch raises when it matches: the overall timeout is ErrSAMLTimeout, and
+// cancelling the login returns context.Canceled.
+func contextErr(ctxt context.Context, s Settings) error {
+	switch ctxt.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%w: login timed out after %v", ErrSAMLTimeout, s.Timeout)
+	}
+	return fmt.Errorf("login cancelled: %w", ctxt.Err())
+}
+
+// printStep prints a log step's message with the backend's name.
+func printStep(b backend, step *Step) {
+	if step.Level == "warn" {
+		logging.Warnf("(%s) %s", b.name(), step.Message)
+	} else {
+		logging.Infof("(%s) %s", b.name(), step.Message)
+	}
+}
+
+func (c *Chrome) name() string {
+	return "chrome"
+}
+
+func (c *Chrome) loginContext() context.Context {
+	return c.Ctxt
+}
+
+func (c *Chrome) password(s Settings, username string) (string, error) {
+	return getPassword(c.Ctxt, s, username)
+}
+
+func (c *Chrome) logStep(step *Step) {
+	printStep(c, step)
+}
+
+// runStep performs a single step in Chrome, returning true if a branch
+// matched.
+func (c *Chrome) runStep(step *Step, s Settings, vars map[string]string) (bool, error) {
+	ctxt, cancel := phaseContext(c.Ctxt, step.Phase, s)
+	defer cancel()
+
+	switch step.Action {
+	case "navigate":
+		if err := c.C.Run(ctxt, chromedp.Navigate(step.URL)); err != nil {
+			return false, step.err(err)
+		}
+
+	case "wait":
+		for !c.present(ctxt, step.Frame, step.Selector, step.Text) {
+			select {
+			case <-ctxt.Done():
+				return false, step.err(fmt.Errorf("timeout waiting for %s", step.Selector))
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+	case "branch":
+		return c.present(c.Ctxt, step.Frame, step.Selector, step.Text), nil
+
+	case "fill":
+		// typing real key events on the top document matches what a user does
+		if step.Frame == "" {
+			if err := c.C.Run(ctxt, chromedp.SendKeys(step.Selector, step.Value)); err != nil {
+				return false, step.err(err)
+			}
+			break
+		}
+		js := `(function(el, v) {
+			if (el === null) { return false; }
+			el.value = v;
+			el.dispatchEvent(new Event("input", {bubbles: true}));
+			return true;
+		})(%s, %s)`
+		if err := c.evalElement(ctxt, step, js, quote(step.Value)); err != nil {
+			return false, err
+		}
+
+	case "submit":
+		if step.Frame == "" {
+			if err := c.C.Run(ctxt, chromedp.Submit(step.Selector)); err != nil {
+				return false, step.err(err)
+			}
+			break
+		}
+		js := `(function(el) {
+			if (el === null || el.form === null) { return false; }
+			el.form.submit();
+			return true;
+		})(%s)`
+		if err := c.evalElement(ctxt, step, js); err != nil {
+			return false, err
+		}
+
+	case "check":
+		js := `(function(el) {
+			if (el === null) { return false; }
+			if (!el.checked) { el.click(); }
+			return true;
+		})(%s)`
+		if err := c.evalElement(ctxt, step, js); err != nil {
+			return false, err
+		}
+
+	case "click":
+		js := `(function(el) {
+			if (el === null) { return false; }
+			el.click();
+			return true;
+		})(%s)`
+		if err := c.evalElement(ctxt, step, js); err != nil {
+			return false, err
+		}
+
+	case "extract":
+		var res interface{}
+		js := fmt.Sprintf(`(function(el, a) {
+			return el === null ? null : el.getAttribute(a);
+		})(%s, %s)`, findJS(step.Frame, step.Selector), quote(step.Attribute))
+		if err := c.C.Run(ctxt, chromedp.Evaluate(js, &res)); err != nil {
+			return false, step.err(err)
+		}
+		value, ok := res.(string)
+		if !ok {
+			return false, step.err(fmt.Errorf("no %s attribute on %s", step.Attribute, step.Selector))
+		}
+		vars[step.Variable] = value
+	}
+
+	return false, nil
+}
+
+// templates returns the step's template fields.
+func (step *Step) templates() []*string {
+	return []*string{
+		&step.URL, &step.Selector, &step.Frame, &step.Text, &step.Value,
+		&step.Attribute, &step.Variable, &step.Message, &step.When,
+	}
+}
+
+// uses reports whether any of the step's template fields use the variable.
+func (step Step) uses(variable string) bool {
+	for _, field := range step.templates() {
+		if strings.Contains(*field, "."+variable) {
+			return true
+		}
+	}
+	return false
+}
+
+// rendered returns a copy of the step with its template fields executed.
+func (step Step) rendered(vars map[string]string) (Step, error) {
+	var err error
+	for _, field := range step.templates() {
+		if *field, err = render(*field, vars); err != nil {
+			return step, err
+		}
+	}
+	return step, nil
+}
+
+// phaseTimeout returns the Settings timeout for phase, and false if the phase
+// has none.
+func phaseTimeout(phase string, s Settings) (time.Duration, bool) {
+	switch phase {
+	case "login":
+		return s.LoginTimeout, true
+	case "duo":
+		return s.DuoTimeout, true
+	case "duo_frame":
+		return s.DuoFrameTimeout, true
+	}
+	return 0, false
+}
+
+// phaseContext returns a context bounded by the Settings timeout for phase.
+func phaseContext(ctxt context.Context, phase string, s Settings) (context.Context, context.CancelFunc) {
+	if timeout, ok := phaseTimeout(phase, s); ok {
+		return context.WithTimeout(ctxt, timeout)
+	}
+	return context.WithCancel(ctxt)
+}
+
+// evalElement runs js, a function expression formatted with the step's
+// element and args, which returns false if the element doesn't exist.
+func (c *Chrome) evalElement(ctxt context.Context, step *Step, js string, args ...interface{}) error {
+	var ok bool
+	args = append([]interface{}{findJS(step.Frame, step.Selector)}, args...)
+	if err := c.C.Run(ctxt, chromedp.Evaluate(fmt.Sprintf(js, args...), &ok)); err != nil {
+		return step.err(err)
+	}
+	if !ok {
+		return step.err(fmt.Errorf("no element matches %s", step.Selector))
+	}
+	return nil
+}
+
+// present reports whether sel matches in frame and, if text is set, the
+// element contains it. An empty sel matches the document body.
+func (c *Chrome) present(ctxt context.Context, frame, sel, text string) bool {
+	var res bool
+	js := fmt.Sprintf(`(function(el, text) {
+		return el !== null && (text === "" || el.textContent.indexOf(text) >= 0);
+	})(%s, %s)`, findJS(frame, sel), quote(text))
+	if err := c.C.Run(ctxt, chromedp.Evaluate(js, &res)); err != nil {
+		return false
+	}
+	return res
+}
+
+// findJS returns a JavaScript expression evaluating to the element matched by
+// sel, XPath or CSS, in the document of the iframe matched by frame or the top
+// document. It evaluates to null if nothing matches.
+func findJS(frame, sel string) string {
+	return fmt.Sprintf(`(function(frame, sel) {
+		var doc = document;
+		if (frame !== "") {
+			var f = document.querySelector(frame);
+			if (f === null) { return null; }
+			doc = f.contentWindow.document;
+		}
+		if (sel === "") { return doc.body; }
+		if (sel[0] === "/" || sel[0] === "(") {
+			return doc.evaluate(sel, doc, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null).singleNodeValue;
+		}
+		return doc.querySelector(sel);
+	})(%s, %s)`, quote(frame), quote(sel))
+}
+
+// quote returns s as a JavaScript string literal.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,107 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContextErr(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctxt context.Context
+		want error
+	}{
+		{"running", context.Background(), nil},
+		{"timed out", expired, ErrSAMLTimeout},
+		{"cancelled", cancelled, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := contextErr(tt.ctxt, DefaultSettings())
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("contextErr() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("contextErr() = %v, want %v", err, tt.want)
+			}
+			// a branch step's error must never be what a timeout reports
+			if errors.Is(err, ErrDuoDenied) || errors.Is(err, ErrFlow) {
+				t.Errorf("contextErr() = %v, wraps a step error", err)
+			}
+		})
+	}
+}
+
+// loopBackend is a backend whose branches match once their selector has been
+// checked matchAfter times.
+type loopBackend struct {
+	matchAfter int
+	checks     int
+}
+
+func (b *loopBackend) name() string                                         { return "test" }
+func (b *loopBackend) loginContext() context.Context                        { return context.Background() }
+func (b *loopBackend) password(s Settings, username string) (string, error) { return "", nil }
+func (b *loopBackend) logStep(step *Step)                                   {}
+func (b *loopBackend) withSnapshot(err error, s Settings, vars map[string]string) error {
+	return err
+}
+
+func (b *loopBackend) runStep(step *Step, s Settings, vars map[string]string) (bool, error) {
+	b.checks++
+	if b.matchAfter >= 0 && b.checks > b.matchAfter {
+		vars["saml_response"] = "assertion"
+		return true, nil
+	}
+	return false, nil
+}
+
+func TestRunFlowLoopLimits(t *testing.T) {
+	loop := func(retries, phase string) Flow {
+		return Flow{Name: "loop", Steps: []Step{
+			{Name: "poll", Action: "branch", Selector: "#ready", Goto: "done"},
+			{Action: "goto", Goto: "poll", Retries: retries, Phase: phase, Error: "duo_timeout"},
+			{Name: "done", Action: "log", Message: "done"},
+		}}
+	}
+	tests := []struct {
+		name       string
+		flow       Flow
+		matchAfter int
+		want       error
+	}{
+		{"matches before the phase timeout", loop("", "duo_frame"), 3, nil},
+		{"phase timeout", loop("", "duo_frame"), -1, ErrDuoTimeout},
+		{"retries", loop("2", ""), -1, ErrDuoTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.DuoFrameTimeout = 100 * time.Millisecond
+			b := &loopBackend{matchAfter: tt.matchAfter}
+
+			start := time.Now()
+			err := runFlow(b, tt.flow, s, map[string]string{})
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("runFlow() error = %v", err)
+				}
+			} else if !errors.Is(err, tt.want) {
+				t.Errorf("runFlow() error = %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("runFlow() took %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package idp
+
+import "time"
+
+// Browser modes for Settings.Browser.
+const (
+	BrowserHeadless = "headless"
+	BrowserVisible  = "visible"
+	// BrowserHTTP runs the flow over plain HTTP without Chrome, for flows
+	// that don't need JavaScript or iframes.
+	BrowserHTTP = "http"
+)
+
+// MFA events reported to Settings.OnMFA.
+const (
+	// MFARequest is reported once the DUO request was sent and cu-sts is
+	// waiting for it to be approved.
+	MFARequest = "duo_request"
+	// MFAResend is reported when a timed out DUO request is re-sent.
+	MFAResend = "duo_resend"
+)
+
+// An MFAEvent tells the OnMFA callback about the DUO step of a login.
+type MFAEvent struct {
+	Username string
+	Method   string
+	Event    string
+	Message  string
+}
+
+// A PromptFunc returns the password for username, e.g. by asking the user.
+type PromptFunc func(username string) (string, error)
+
+// Settings controls how Login logs in.
+type Settings struct {
+	// Flow is the login flow to run, DefaultFlow() if it has no steps.
+	Flow Flow
+
+	// DuoMethod is the DUO method to use, "push" or "call".
+	DuoMethod string
+	// Browser is BrowserHeadless to run the flow, BrowserVisible to show
+	// Chrome and let the user log in by hand, or BrowserHTTP to run the flow
+	// without Chrome.
+	Browser string
+	// Debug sends Chrome debug output to the logging package.
+	Debug bool
+	// BrowserProfileDir, if set, is a persistent Chrome user-data directory so
+	// the IdP session and DUO's remembered device survive between logins.
+	BrowserProfileDir string
+	// CookieDir, if set, keeps the IdP session cookies encrypted in this
+	// directory and tries a silent SSO with them before starting Chrome.
+	CookieDir string
+	// DebugDir, if set, is where a screenshot and the page HTML are saved
+	// when a login step fails.
+	DebugDir string
+	// DuoRemember ticks DUO's "Remember me" checkbox.
+	DuoRemember bool
+
+	// Prompt, if set, is called for the password instead of prompting on
+	// the terminal. PasswordCommand takes precedence.
+	Prompt PromptFunc
+	// OnMFA, if set, is called with the flow's MFA events, e.g. to tell the
+	// user to approve the DUO push.
+	OnMFA func(MFAEvent)
+
+	// PasswordCommand, if set, is run to get the password instead of
+	// prompting for it. It is split into words without a shell unless
+	// PasswordCommandShell is set.
+	PasswordCommand      string
+	PasswordCommandShell bool
+	// PasswordCommandTimeout bounds running the PasswordCommand.
+	PasswordCommandTimeout time.Duration
+
+	// Timeout bounds the whole login, including waiting for the DUO response.
+	Timeout time.Duration
+	// LoginTimeout bounds loading and submitting the NetID/password page.
+	LoginTimeout time.Duration
+	// DuoTimeout bounds loading the DUO frame and selecting the method.
+	DuoTimeout time.Duration
+	// DuoFrameTimeout is how long to poll for the DUO frame's checkbox and buttons.
+	DuoFrameTimeout time.Duration
+
+	// DuoRetries is how many times to re-send the DUO request after DUO
+	// reports it timed out.
+	DuoRetries int
+	// DuoFallback, if set, is the DUO method used for re-sent requests.
+	DuoFallback string
+}
+
+// DefaultSettings returns Settings with cu-sts' default timeouts and no retries.
+func DefaultSettings() Settings {
+	return Settings{
+		DuoMethod:              "push",
+		Browser:                BrowserHeadless,
+		Timeout:                120 * time.Second,
+		PasswordCommandTimeout: 30 * time.Second,
+		LoginTimeout:           15 * time.Second,
+		DuoTimeout:             30 * time.Second,
+		DuoFrameTimeout:        20 * time.Second,
+	}
+}
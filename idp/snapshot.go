@@ -0,0 +1,118 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cu-sts/logging"
+
+	"github.com/chromedp/chromedp"
+)
+
+// pageDocument is the HTML of the page or one of its iframes.
+type pageDocument struct {
+	Frame string `json:"frame"`
+	URL   string `json:"url"`
+	HTML  string `json:"html"`
+}
+
+// documentsJS collects the top document and every same-origin iframe document,
+// which with web security disabled includes the DUO frame.
+const documentsJS = `(function() {
+	var docs = [{frame: "", url: document.URL, html: document.documentElement.outerHTML}];
+	var frames = document.querySelectorAll("iframe");
+	for (var i = 0; i < frames.length; i++) {
+		var f = frames[i];
+		var name = f.id ? "#" + f.id : "iframe[" + i + "]";
+		try {
+			var d = f.contentWindow.document;
+			docs.push({frame: name, url: d.URL, html: d.documentElement.outerHTML});
+		} catch (e) {
+			docs.push({frame: name, url: f.src, html: "<!-- unreadable: " + e + " -->"});
+		}
+	}
+	return JSON.stringify(docs);
+})()`
+
+// snapshot saves a screenshot, the page and iframe HTML and the current URL to
+// a new timestamped directory under s.DebugDir, returning the directory. The
+// password, SAML assertions, cookies and other secrets the logging package
+// knows are redacted from everything but the screenshot, where Chrome only
+// shows the password masked.
+func (c *Chrome) snapshot(s Settings, password string) (string, error) {
+	if err := userOnlyDir(s.DebugDir); err != nil {
+		return "", err
+	}
+	// concurrent logins can fail in the same second, so add a unique suffix
+	dir, err := ioutil.TempDir(s.DebugDir, time.Now().Format("20060102-150405-"))
+	if err != nil {
+		return "", err
+	}
+
+	// the flow's context may be what timed out, so use a fresh one
+	ctxt, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	redact := func(text string) string {
+		if password != "" {
+			text = strings.Replace(text, password, logging.Redacted, -1)
+		}
+		return logging.Redact(text)
+	}
+
+	var location string
+	if err := c.C.Run(ctxt, chromedp.Location(&location)); err != nil {
+		return dir, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "url.txt"), []byte(redact(location)+"\n"), 0600); err != nil {
+		return dir, err
+	}
+
+	var res string
+	if err := c.C.Run(ctxt, chromedp.Evaluate(documentsJS, &res)); err != nil {
+		return dir, err
+	}
+	var docs []pageDocument
+	if err := json.Unmarshal([]byte(res), &docs); err != nil {
+		return dir, err
+	}
+	for i, doc := range docs {
+		name := "page.html"
+		if i > 0 {
+			name = fmt.Sprintf("frame-%d.html", i)
+		}
+		html := fmt.Sprintf("<!-- frame: %s url: %s -->\n%s", doc.Frame, doc.URL, doc.HTML)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(redact(html)), 0600); err != nil {
+			return dir, err
+		}
+	}
+
+	var png []byte
+	if err := c.C.Run(ctxt, chromedp.CaptureScreenshot(&png)); err != nil {
+		return dir, err
+	}
+	return dir, ioutil.WriteFile(filepath.Join(dir, "screenshot.png"), png, 0600)
+}
+
+// withSnapshot saves a snapshot of the failed step's page and adds its
+// directory to err. An incomplete snapshot is deleted rather than reported.
+func (c *Chrome) withSnapshot(err error, s Settings, vars map[string]string) error {
+	if s.DebugDir == "" {
+		return err
+	}
+	dir, serr := c.snapshot(s, vars["password"])
+	if serr != nil {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+		logging.Warnf("Problem saving debug snapshot: %v", serr)
+		return err
+	}
+	return fmt.Errorf("%w (debug snapshot saved to %s)", err, dir)
+}
@@ -0,0 +1,34 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"cu-sts/logging"
+)
+
+// errNoSession is returned by silentSSO when the IdP wants a full login.
+var errNoSession = errors.New("no valid IdP session")
+
+// silentSSO runs the flow over plain HTTP with the jar's IdP session cookies
+// to get the SAMLResponse without prompting or starting Chrome. It returns
+// errNoSession as soon as the flow needs the password, i.e. the IdP wants a
+// full login, and any other error once the flow needs Chrome.
+func silentSSO(ctxt context.Context, f Flow, jar http.CookieJar, vars map[string]string, s Settings) (string, error) {
+	ctxt, cancel := context.WithTimeout(ctxt, s.LoginTimeout)
+	defer cancel()
+
+	// the password isn't needed while the session is valid, even if known
+	silent := make(map[string]string, len(vars))
+	for k, v := range vars {
+		silent[k] = v
+	}
+	silent["password"] = ""
+
+	logging.Infof("(http) Trying stored IdP session.")
+	if err := runFlow(newHTTPBackend(ctxt, jar, true), f, s, silent); err != nil {
+		return "", err
+	}
+	return silent["saml_response"], nil
+}
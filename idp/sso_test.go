@@ -0,0 +1,122 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cornellFlowAt returns the built-in flow starting at u instead of signin-sts.
+func cornellFlowAt(t *testing.T, u string) Flow {
+	f := DefaultFlow()
+	for i, step := range f.Steps {
+		if step.Action == "navigate" {
+			f.Steps[i].URL = u
+			return f
+		}
+	}
+	t.Fatal("built-in flow never navigates")
+	return f
+}
+
+func TestSilentSSO(t *testing.T) {
+	samlForm := func(action string) string {
+		return fmt.Sprintf(`<form method="post" action="%s">
+<input type="hidden" name="SAMLResponse" value="PHNhbWw+">
+<input type="hidden" name="RelayState" value="state">
+</form>`, action)
+	}
+	tests := []struct {
+		name    string
+		pages   map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "session valid",
+			pages: map[string]string{
+				"/":    samlForm("/acs"),
+				"/acs": `<input type="hidden" id="saml_response" value="assertion">`,
+			},
+			want: "assertion",
+		},
+		{
+			name: "login page",
+			pages: map[string]string{"/": `<form action="/login" method="post">
+<input id="netid" name="j_username"><input id="password" name="j_password" type="password">
+</form>`},
+			wantErr: errNoSession,
+		},
+		{
+			name:    "unknown page",
+			pages:   map[string]string{"/": `<p>Your password has expired.</p>`},
+			wantErr: ErrLoginPage,
+		},
+		{
+			name:    "endless forms",
+			pages:   map[string]string{"/": samlForm("/"), "/acs": samlForm("/")},
+			wantErr: ErrLoginPage,
+		},
+		{
+			// the POST fails to connect, which used to dereference a nil response
+			name:    "failing post",
+			pages:   map[string]string{"/": samlForm("http://127.0.0.1:1/acs")},
+			wantErr: ErrLoginPage,
+		},
+		{
+			name:    "server error",
+			pages:   map[string]string{},
+			wantErr: ErrLoginPage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page, ok := tt.pages[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, page)
+			}))
+			defer server.Close()
+
+			s := DefaultSettings()
+			s.LoginTimeout = 5 * time.Second
+			// a known password must not be sent without the user's session
+			vars := map[string]string{"username": "abc1", "password": "secret"}
+			got, err := silentSSO(context.Background(), cornellFlowAt(t, server.URL+"/"), &cookieJar{}, vars, s)
+			if tt.want != "" {
+				if err != nil || got != tt.want {
+					t.Fatalf("silentSSO() = %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("silentSSO() = %q, want an error", got)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("silentSSO() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSilentSSOTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	s := DefaultSettings()
+	s.LoginTimeout = 50 * time.Millisecond
+	_, err := silentSSO(context.Background(), cornellFlowAt(t, server.URL), &cookieJar{}, map[string]string{}, s)
+	if !errors.Is(err, ErrSAMLTimeout) {
+		t.Errorf("silentSSO() error = %v, want %v", err, ErrSAMLTimeout)
+	}
+}
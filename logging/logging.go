@@ -0,0 +1,131 @@
+// Package logging provides cu-sts' leveled diagnostic output. Everything is
+// written to STDERR so STDOUT stays usable for command output.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// Log levels, in increasing severity.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Supported output formats.
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+var (
+	mu     sync.Mutex
+	level            = InfoLevel
+	format           = TextFormat
+	out    io.Writer = os.Stderr
+)
+
+// SetLevel sets the minimum level of messages that are written.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetFormat sets the output format to "text" or "json".
+func SetFormat(f string) error {
+	if f != TextFormat && f != JSONFormat {
+		return fmt.Errorf("unknown log format %s, must be %s or %s", f, TextFormat, JSONFormat)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+	return nil
+}
+
+// SetOutput sets the writer messages are written to, STDERR by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Enabled reports whether messages at l would be written.
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level
+}
+
+// Debugf logs a debug message. Its signature matches chromedp.LogFunc.
+func Debugf(msg string, args ...interface{}) {
+	logf(DebugLevel, msg, args...)
+}
+
+// Infof logs a progress message.
+func Infof(msg string, args ...interface{}) {
+	logf(InfoLevel, msg, args...)
+}
+
+// Warnf logs a recoverable problem.
+func Warnf(msg string, args ...interface{}) {
+	logf(WarnLevel, msg, args...)
+}
+
+// Errorf logs a failure.
+func Errorf(msg string, args ...interface{}) {
+	logf(ErrorLevel, msg, args...)
+}
+
+func logf(l Level, msg string, args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l < level {
+		return
+	}
+	msg = Redact(strings.TrimSpace(fmt.Sprintf(msg, args...)))
+
+	if format == JSONFormat {
+		line, _ := json.Marshal(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"msg"`
+		}{time.Now().UTC().Format(time.RFC3339), l.String(), msg})
+		fmt.Fprintln(out, string(line))
+		return
+	}
+
+	switch l {
+	case WarnLevel:
+		color.New(color.FgYellow).Fprintln(out, msg)
+	case ErrorLevel:
+		color.New(color.FgRed).Fprintf(out, "ERROR: %s\n", msg)
+	default:
+		fmt.Fprintln(out, msg)
+	}
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in log messages.
+const Redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+)
+
+// secretPatterns match secrets that aren't known up front. Each keeps its
+// first group, the name of the secret, and redacts the rest of the match.
+var secretPatterns = []*regexp.Regexp{
+	// AWS secret keys and session tokens in JSON, INI files and environment
+	regexp.MustCompile(`(?i)("?(?:aws_secret_access_key|aws_session_token|secretaccesskey|sessiontoken|secret_access_key|session_token)"?\s*[:=]\s*"?)[^"\s,}]+`),
+	// SAML assertions, in forms, URLs and input values
+	regexp.MustCompile(`((?:SAMLResponse|saml_response)\\*"?[^A-Za-z0-9+/]{1,40}(?:value\s*=\s*\\*"?)?)[A-Za-z0-9+/=%]{20,}`),
+	// cookie headers
+	regexp.MustCompile(`(?i)(\b(?:set-)?cookie\\*"?\s*:\s*\\*"?)[^"\\\n]+`),
+	// any other long base-64 blob, which is most likely an assertion or token
+	regexp.MustCompile(`()[A-Za-z0-9+/]{200,}={0,2}`),
+}
+
+// AddSecret makes every later log message replace s with Redacted, including
+// where it is quoted in JSON or JavaScript.
+func AddSecret(s string) {
+	if s == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	// quoted once in JavaScript, and again in the CDP message carrying it
+	once, _ := json.Marshal(s)
+	twice, _ := json.Marshal(string(once))
+	for _, v := range []string{s, string(once[1 : len(once)-1]), string(twice[3 : len(twice)-3])} {
+		known := false
+		for _, secret := range secrets {
+			known = known || secret == v
+		}
+		if !known {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact returns msg with the added secrets and anything that looks like an
+// AWS secret, SAML assertion or cookie replaced by Redacted.
+func Redact(msg string) string {
+	secretsMu.Lock()
+	for _, s := range secrets {
+		msg = strings.Replace(msg, s, Redacted, -1)
+	}
+	secretsMu.Unlock()
+
+	for _, p := range secretPatterns {
+		msg = p.ReplaceAllString(msg, "${1}"+Redacted)
+	}
+	return msg
+}
@@ -0,0 +1,135 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/viper"
+)
+
+var accountPattern = regexp.MustCompile(`^\d{12}$`)
+
+// Aliases returns the cached account number to account alias mapping. A
+// missing cache file is not an error.
+func Aliases() (map[string]string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return make(map[string]string), err
+	}
+	return loadAliases(dir)
+}
+
+// loadAliases returns the alias cache in the cache directory dir.
+func loadAliases(dir string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	path := filepath.Join(dir, "aliases.json")
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	if err != nil {
+		return aliases, fmt.Errorf("unable to read alias cache: %v", err)
+	}
+	if err = json.Unmarshal(data, &aliases); err != nil {
+		return aliases, fmt.Errorf("unable to decode alias cache %s: %v", path, err)
+	}
+	return aliases, nil
+}
+
+// Alias returns the cached alias for an account number, or an empty string.
+func Alias(account string) string {
+	aliases, _ := Aliases()
+	return aliases[account]
+}
+
+// SaveAlias stores an account's alias in the local cache.
+func SaveAlias(account, alias string) error {
+	aliases, err := Aliases()
+	if err != nil {
+		return err
+	}
+	aliases[account] = alias
+
+	dir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "aliases.json")
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// ResolveAccount returns the account number for either a twelve digit account
+// number or a previously cached account alias.
+func ResolveAccount(account string) (string, error) {
+	return resolveAccount(viper.GetViper(), account)
+}
+
+// resolveAccount is ResolveAccount with the alias cache of the config in v.
+func resolveAccount(v *viper.Viper, account string) (string, error) {
+	if account == "" || accountPattern.MatchString(account) {
+		return account, nil
+	}
+
+	dir, err := ConfigCacheDir(v)
+	if err != nil {
+		return "", err
+	}
+	aliases, err := loadAliases(dir)
+	if err != nil {
+		return "", err
+	}
+	for number, alias := range aliases {
+		if alias == account {
+			return number, nil
+		}
+	}
+	return "", fmt.Errorf("unknown account alias %s, use the account number once with --resolve-aliases to cache it", account)
+}
+
+// Label returns the Profile's account alias if known, otherwise the account number.
+func (p *Profile) Label() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	return p.Account
+}
+
+// LookupAlias uses STS credentials for the Profile to call iam:ListAccountAliases,
+// caching and setting the Profile's Alias if the account has one.
+func (p *Profile) LookupAlias(creds *sts.Credentials) error {
+	sess := session.New(&aws.Config{
+		Credentials: credentials.NewStaticCredentials(
+			*creds.AccessKeyId,
+			*creds.SecretAccessKey,
+			*creds.SessionToken,
+		),
+	})
+
+	resp, err := iam.New(sess).ListAccountAliases(&iam.ListAccountAliasesInput{})
+	if err != nil {
+		return fmt.Errorf("unable to list account aliases for %s: %v", p.Account, err)
+	}
+	if len(resp.AccountAliases) == 0 {
+		return nil
+	}
+
+	p.Alias = *resp.AccountAliases[0]
+	return SaveAlias(p.Account, p.Alias)
+}
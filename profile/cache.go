@@ -0,0 +1,98 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+// DefaultCacheDir is where cu-sts keeps local state unless cache_dir is set.
+const DefaultCacheDir = "~/.cu-sts"
+
+// CacheDir returns the expanded cache directory. It isn't created, so callers
+// that write to it must create it.
+func CacheDir() (string, error) {
+	return ConfigCacheDir(viper.GetViper())
+}
+
+// ConfigCacheDir returns the expanded cache directory of the config in v, like
+// CacheDir.
+func ConfigCacheDir(v *viper.Viper) (string, error) {
+	path := v.GetString("cache_dir")
+	if path == "" {
+		path = DefaultCacheDir
+	}
+
+	dir, err := homedir.Expand(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to expand cache_dir %s: %v", path, err)
+	}
+	return dir, nil
+}
+
+// CachedCredentials are a profile's credentials in the credential cache,
+// process.json in the cache directory, which `cu-sts process` and `env` write
+// in the credential_process JSON format.
+type CachedCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+	RoleArn         string
+}
+
+// STS returns the cached credentials as STS credentials.
+func (c *CachedCredentials) STS() *sts.Credentials {
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(c.AccessKeyId),
+		SecretAccessKey: aws.String(c.SecretAccessKey),
+		SessionToken:    aws.String(c.SessionToken),
+		Expiration:      aws.Time(c.Expiration),
+	}
+}
+
+// CredentialsCachePath returns the path of the credential cache in the cache
+// directory dir.
+func CredentialsCachePath(dir string) string {
+	return filepath.Join(dir, "process.json")
+}
+
+// LoadCachedCredentials returns the named profile's credentials from the
+// credential cache in the cache directory dir if they're valid for more than
+// window, or nil if there are none. A missing cache file is not an error.
+func LoadCachedCredentials(dir, name string, window time.Duration) (*CachedCredentials, error) {
+	path := CredentialsCachePath(dir)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credential cache: %v", err)
+	}
+
+	var objs map[string]json.RawMessage
+	if err = json.Unmarshal(data, &objs); err != nil {
+		return nil, fmt.Errorf("unable to decode credential cache %s: %v", path, err)
+	}
+	raw, ok := objs[name]
+	if !ok {
+		return nil, nil
+	}
+	var c CachedCredentials
+	if err = json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("unable to decode %s in credential cache %s: %v", name, path, err)
+	}
+	if time.Until(c.Expiration) <= window || c.AccessKeyId == "" {
+		return nil, nil
+	}
+	return &c, nil
+}
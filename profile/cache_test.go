@@ -0,0 +1,51 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadCachedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cu-sts-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	expiring := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		cache   string
+		want    string
+		wantErr bool
+	}{
+		{"no cache", "", "", false},
+		{"valid", `{"admin": {"AccessKeyId": "AKIA1", "Expiration": "` + valid + `"}}`, "AKIA1", false},
+		{"other profile", `{"dev": {"AccessKeyId": "AKIA1", "Expiration": "` + valid + `"}}`, "", false},
+		{"within window", `{"admin": {"AccessKeyId": "AKIA1", "Expiration": "` + expiring + `"}}`, "", false},
+		{"no expiration", `{"admin": {"AccessKeyId": "AKIA1"}}`, "", false},
+		{"corrupt", `{"admin": `, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(filepath.Join(dir, "process.json"), []byte(tt.cache), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadCachedCredentials(dir, "admin", 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadCachedCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var key string
+			if got != nil {
+				key = got.AccessKeyId
+			}
+			if key != tt.want {
+				t.Errorf("LoadCachedCredentials() = %q, want %q", key, tt.want)
+			}
+		})
+	}
+}
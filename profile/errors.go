@@ -0,0 +1,42 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Errors returned by NewFromConfig and Credentials. They wrap the underlying
+// error, so callers should compare with errors.Is.
+var (
+	// ErrProfileNotFound is returned when a profile isn't in the config file.
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrInvalidProfile is returned when a profile is missing or has bad values.
+	ErrInvalidProfile = errors.New("invalid profile")
+	// ErrAccessDenied is returned when STS refuses to assume the role, e.g. the
+	// role doesn't exist or the user isn't in its shib-* group.
+	ErrAccessDenied = errors.New("access denied assuming role")
+	// ErrAssertionRejected is returned when STS rejects or considers the SAML
+	// assertion expired. Logging in again may succeed.
+	ErrAssertionRejected = errors.New("SAML assertion rejected")
+	// ErrSTS is returned for any other STS failure.
+	ErrSTS = errors.New("STS request failed")
+)
+
+// stsError classifies an AssumeRoleWithSAML error as one of the exported values.
+func stsError(err error) error {
+	kind := ErrSTS
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDenied":
+			kind = ErrAccessDenied
+		case "ValidationError":
+			kind = ErrInvalidProfile
+		case sts.ErrCodeExpiredTokenException, sts.ErrCodeIDPRejectedClaimException:
+			kind = ErrAssertionRejected
+		}
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
@@ -0,0 +1,79 @@
+package profile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Hook failure policies.
+const (
+	HookWarn  = "warn"
+	HookAbort = "abort"
+)
+
+// DefaultHookTimeout is how long a Hook without a timeout may run.
+const DefaultHookTimeout = 30 * time.Second
+
+// A Hook is an external command run around issuing a profile's credentials.
+// Command is split into words like password_command unless Shell is set.
+// OnFailure is HookWarn (the default) or HookAbort.
+type Hook struct {
+	Command   string `mapstructure:"command"`
+	Shell     bool   `mapstructure:"shell"`
+	Timeout   int    `mapstructure:"timeout"`
+	OnFailure string `mapstructure:"on_failure"`
+}
+
+// Hooks are the commands run before logging in, after credentials are
+// issued, and after exec's sub-command exits.
+type Hooks struct {
+	PreLogin        []Hook `mapstructure:"pre_login"`
+	PostCredentials []Hook `mapstructure:"post_credentials"`
+	PostExec        []Hook `mapstructure:"post_exec"`
+}
+
+// GlobalHooks returns the top-level [hooks] from the loaded viper config
+// file, which run for every profile before the profile's own hooks.
+func GlobalHooks() (Hooks, error) {
+	var h Hooks
+	if err := viper.UnmarshalKey("hooks", &h); err != nil {
+		return h, fmt.Errorf("unable to decode hooks: %v", err)
+	}
+	return h, h.Validate()
+}
+
+// Validate ensures every Hook has a command and a valid timeout and failure
+// policy.
+func (h *Hooks) Validate() error {
+	phases := []struct {
+		name  string
+		hooks []Hook
+	}{
+		{"pre_login", h.PreLogin},
+		{"post_credentials", h.PostCredentials},
+		{"post_exec", h.PostExec},
+	}
+	for _, phase := range phases {
+		for i, hook := range phase.hooks {
+			switch {
+			case hook.Command == "":
+				return fmt.Errorf("hooks.%s[%d] is missing required key \"command\"", phase.name, i)
+			case hook.Timeout < 0:
+				return fmt.Errorf("hooks.%s[%d] timeout must be a positive number of seconds", phase.name, i)
+			case hook.OnFailure != "" && hook.OnFailure != HookWarn && hook.OnFailure != HookAbort:
+				return fmt.Errorf("hooks.%s[%d] has unknown on_failure %s, must be warn or abort", phase.name, i, hook.OnFailure)
+			}
+		}
+	}
+	return nil
+}
+
+// TimeoutDuration returns how long the Hook may run.
+func (h *Hook) TimeoutDuration() time.Duration {
+	if h.Timeout == 0 {
+		return DefaultHookTimeout
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
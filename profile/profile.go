@@ -3,6 +3,9 @@ package profile
 
 import (
 	"fmt"
+	"strings"
+
+	"cu-sts/logging"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -17,6 +20,34 @@ type Profile struct {
 	Role       string `mapstructure:"role"`
 	IDProvider string `mapstructure:"id_provider"`
 	Duration   int    `mapstructure:"duration"`
+	Alias      string
+
+	// Username and DuoMethod are the identity used to log in to the IdP,
+	// from the top-level config keys unless the profile sets its own.
+	Username  string `mapstructure:"username"`
+	DuoMethod string `mapstructure:"duo_method"`
+
+	// Targets names the [target.NAME] sections creds writes the profile's
+	// credentials to.
+	Targets []string `mapstructure:"targets"`
+
+	// Region and Output are written to the profile's ~/.aws/config block by
+	// config export-aws.
+	Region string `mapstructure:"region"`
+	Output string `mapstructure:"output"`
+
+	// Protected profiles need confirmation before credentials are issued.
+	// Confirm is what must be typed: "alias", "account", "profile" or any
+	// literal text, and implies Protected. Color is the banner's color.
+	Protected bool   `mapstructure:"protected"`
+	Confirm   string `mapstructure:"confirm"`
+	Color     string `mapstructure:"color"`
+
+	// Hooks run after the top-level [hooks] for this profile.
+	Hooks Hooks `mapstructure:"hooks"`
+
+	// AssumedRoleARN is the ARN of the last credentials' assumed-role session.
+	AssumedRoleARN string
 }
 
 // Profiles returns all profiles from the loaded viper config file.
@@ -32,41 +63,79 @@ func New() Profile {
 	}
 }
 
+// Identity returns the NetID and DUO method the profile logs in with.
+// Profiles with the same Identity can share a login.
+func (p *Profile) Identity() string {
+	return fmt.Sprintf("%s (%s)", p.Username, p.DuoMethod)
+}
+
 // NewFromConfig returns a Profile with values set from default, config, or flags.
 func NewFromConfig(name string) (Profile, error) {
+	return FromConfig(viper.GetViper(), name)
+}
+
+// FromConfig returns the named Profile from the config in v, like
+// NewFromConfig does from the global viper instance.
+func FromConfig(v *viper.Viper, name string) (Profile, error) {
 	p := New()
 	p.Name = name
 
-	if _, ok := Profiles()[name]; !ok {
-		return p, fmt.Errorf("unable to find profile %s in config", name)
+	if _, ok := v.GetStringMap("profile")[name]; !ok {
+		return p, fmt.Errorf("%w: no [profile.%s] in config", ErrProfileNotFound, name)
 	}
 	sectionKey := fmt.Sprintf("profile.%s", name)
-	section := viper.Sub(sectionKey)
+	section := v.Sub(sectionKey)
 	err := section.Unmarshal(&p)
 	if err != nil {
-		return p, fmt.Errorf("unable to decode %s into struct: %v", name, err)
+		return p, fmt.Errorf("%w: unable to decode %s: %v", ErrInvalidProfile, name, err)
 	}
 
 	// Override values from config w/ flag since unmarshalling from a viper sub
 	// doesn't get the dot-path overrides from flag binding. So we have to check
 	// if the struct has changed AND if the flag-set value is non-default.
 	// https://github.com/spf13/viper/issues/307
-	if viper.GetInt("duration") != 3600 {
-		p.Duration = viper.GetInt("duration")
+	// A config without the CLI's flags bound may not set them at all.
+	if d := v.GetInt("duration"); d != 0 && d != 3600 {
+		p.Duration = d
+	}
+
+	if idp := v.GetString("id_provider"); idp != "" && idp != "cornell_idp" {
+		p.IDProvider = idp
 	}
 
-	if viper.GetString("id_provider") != "cornell_idp" {
-		p.IDProvider = viper.GetString("id_provider")
+	if p.Username == "" {
+		p.Username = v.GetString("username")
+	}
+	if p.DuoMethod == "" {
+		p.DuoMethod = v.GetString("duo_method")
+	}
+	if p.DuoMethod == "" {
+		p.DuoMethod = "push"
+	}
+
+	if p.Account, err = resolveAccount(v, p.Account); err != nil {
+		return p, fmt.Errorf("%w: %s: %v", ErrInvalidProfile, name, err)
+	}
+	if dir, err := ConfigCacheDir(v); err == nil {
+		aliases, _ := loadAliases(dir)
+		p.Alias = aliases[p.Account]
+	}
+	if p.Confirm != "" {
+		p.Protected = true
 	}
 
 	if err = p.Validate(); err != nil {
-		return p, fmt.Errorf("error validating profile %s: %v", name, err)
+		return p, fmt.Errorf("%w: %s: %v", ErrInvalidProfile, name, err)
 	}
 
 	return p, nil
 }
 
-// Validate ensures a Profile's Account and Role are set.
+// Colors are the banner colors a Profile can use.
+var Colors = []string{"red", "yellow", "green", "blue", "magenta", "cyan", "white"}
+
+// Validate ensures a Profile's Account, Role and Username are set and its
+// DuoMethod, Color and Hooks are valid.
 func (p *Profile) Validate() error {
 	if p.Account == "" {
 		return fmt.Errorf(`missing required key "account"`)
@@ -74,12 +143,32 @@ func (p *Profile) Validate() error {
 	if p.Role == "" {
 		return fmt.Errorf(`missing required key "role"`)
 	}
-	return nil
+	if p.Username == "" {
+		return fmt.Errorf(`missing required key "username"`)
+	}
+	if p.DuoMethod != "push" && p.DuoMethod != "call" {
+		return fmt.Errorf("unknown DUO method %s, must be push or call", p.DuoMethod)
+	}
+	if p.Color != "" {
+		known := false
+		for _, c := range Colors {
+			known = known || c == p.Color
+		}
+		if !known {
+			return fmt.Errorf("unknown color %s, must be one of %s", p.Color, strings.Join(Colors, ", "))
+		}
+	}
+	return p.Hooks.Validate()
 }
 
 // Credentials requires a base-64 SAMLAssertion and returns AWS sts.Credentials
 // using the Profile's role, idprovider, etc.
 func (p *Profile) Credentials(samlAssertion string) (*sts.Credentials, error) {
+	return p.CredentialsWithContext(aws.BackgroundContext(), samlAssertion)
+}
+
+// CredentialsWithContext is Credentials with a context for the STS request.
+func (p *Profile) CredentialsWithContext(ctx aws.Context, samlAssertion string) (*sts.Credentials, error) {
 	principalArn := fmt.Sprintf("arn:aws:iam::%s:saml-provider/%s", p.Account, p.IDProvider)
 	roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", p.Account, p.Role)
 	durationI64 := int64(p.Duration)
@@ -91,9 +180,14 @@ func (p *Profile) Credentials(samlAssertion string) (*sts.Credentials, error) {
 		DurationSeconds: &durationI64,
 		SAMLAssertion:   &samlAssertion,
 	}
-	resp, err := svc.AssumeRoleWithSAML(input)
+	resp, err := svc.AssumeRoleWithSAMLWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, stsError(err)
+	}
+	if resp.AssumedRoleUser != nil {
+		p.AssumedRoleARN = aws.StringValue(resp.AssumedRoleUser.Arn)
 	}
+	logging.AddSecret(aws.StringValue(resp.Credentials.SecretAccessKey))
+	logging.AddSecret(aws.StringValue(resp.Credentials.SessionToken))
 	return resp.Credentials, nil
 }